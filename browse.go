@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Command opening URL in the default browser of the current OS
+func openCommand(u string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u)
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	}
+	return exec.Command("xdg-open", u)
+}
+
+// Compare pages of repos having at least least new commits, in order of reports
+func CompareURLs(reports []straightup.Result, least int) []string {
+	var l []string
+	for _, v := range reports {
+		switch v.Status {
+		case straightup.StatusUpdated, straightup.StatusPending, straightup.StatusPartial:
+			if v.CompareURL != "" && v.NewCommits >= least {
+				l = append(l, v.CompareURL)
+			}
+		}
+	}
+	return l
+}
+
+// Open compare pages of repos updated by at least --open commits in browser,
+// failures are only reported
+func OpenCompare(reports []straightup.Result) {
+	if *openMin <= 0 {
+		return
+	}
+	for _, u := range CompareURLs(reports, *openMin) {
+		cmd := openCommand(u)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			warn("cannot open", u+":", strings.TrimSpace(err.Error()+"\n"+string(out)))
+		}
+	}
+}
@@ -0,0 +1,921 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Reset worktree of repo p hard to the commit of update marker, return
+// the old and new HEAD hashes
+func RollbackGitRepo(p string, force bool) (old, new plumbing.Hash, err error) {
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return
+	}
+	head, err := r.Head()
+	if err != nil {
+		return
+	}
+	tag, err := straightup.MarkedCommit(r)
+	if err == straightup.ErrNoMarker {
+		err = errors.New("no update marker, nothing to roll back to")
+	}
+	if err != nil {
+		return
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return
+	}
+	if !force {
+		var files []string
+		if files, err = straightup.DirtyFiles(w); err != nil {
+			return
+		}
+		if len(files) > 0 {
+			err = fmt.Errorf("%d files have uncommitted changes, use --force to discard them", len(files))
+			return
+		}
+	}
+	err = w.Reset(&git.ResetOptions{Commit: tag.Hash(), Mode: git.HardReset})
+	return head.Hash(), tag.Hash(), err
+}
+
+// updstraight rollback [--force] [repo...]
+func runRollback(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	force := fs.Bool("force", false, "discard uncommitted changes of repos")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight rollback [--force] [repo...]")
+		fmt.Fprintln(fs.Output(), "Reset repos (all if none given) hard to their update marker, i.e. the commit before the last update.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	LockRun(context.Background())
+	repos, _ := SelectRepos(fs.Args())
+
+	var (
+		failed  error
+		changed []string
+	)
+	for _, p := range repos {
+		old, new, err := RollbackGitRepo(p, *force)
+		switch {
+		case err != nil:
+			failed = errors.Join(failed, err)
+			fmt.Println(output.String("Failed", p+":", straightup.Scrub(err.Error())).Foreground(output.Color(palette.Error)))
+		case old == new:
+			fmt.Println(output.String(filepath.Base(p), "already at", new.String()[:6]).Faint())
+		default:
+			changed = append(changed, filepath.Base(p))
+			fmt.Println(
+				output.String("Rolled back", filepath.Base(p)).Foreground(output.Color(palette.Notice)),
+				output.String(old.String()[:6], "->", new.String()[:6]).Foreground(output.Color(palette.Hash)),
+			)
+		}
+	}
+
+	if err := RestartEmacsIfNeeded(changed); err != nil {
+		log.Print(err)
+		os.Exit(ExitRestart)
+	}
+	if failed != nil {
+		os.Exit(ExitFailed)
+	}
+}
+
+// Fetch remote of repo p and render commits between update marker (or HEAD
+// if there is no marker) and the remote-tracking branch, worktree is not touched
+func PreviewGitRepo(p string, buf *bytes.Buffer) error {
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	rr, err := straightup.UpstreamRemote(r, head)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := NetworkContext()
+	defer cancel()
+	if err = straightup.FetchGitChanges(ctx, rr, network()); err != nil {
+		return err
+	}
+	tip, err := straightup.RemoteTrackingRef(r, rr.Config().Name, straightup.ConfiguredBranch(r, branches[filepath.Base(p)], head))
+	if err != nil {
+		return err
+	}
+	base, err := straightup.MarkedCommit(r)
+	if err == straightup.ErrNoMarker {
+		base, err = head, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var n int
+	l, err := straightup.GetGitLog(r, base, tip, &n, theme())
+	if err != nil || n == 0 {
+		return err
+	}
+	fmt.Fprintln(buf,
+		output.String("Upcoming from", straightup.Scrub(rr.Config().URLs[0])).Foreground(output.Color(palette.Header)),
+		output.String(strconv.Itoa(n), "new commits").Foreground(output.Color(palette.Count)),
+	)
+	fmt.Fprintln(buf, output.String("local path:", p).Faint())
+	buf.WriteString(l)
+	return nil
+}
+
+// updstraight preview [repo...]
+func runPreview(args []string) {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight preview [repo...]")
+		fmt.Fprintln(fs.Output(), "Fetch repos (all if none given) and show commits since the last update up to the remote branch, without merging.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	LockRun(context.Background()) // the fetch moves remote-tracking refs
+	repos, _ := SelectRepos(fs.Args())
+	var (
+		pr     RepoPrinter
+		failed atomic.Bool
+	)
+	ForEachRepo(repos, func(i int, p string) {
+		var buf bytes.Buffer
+		switch err := PreviewGitRepo(p, &buf); {
+		case err == straightup.ErrNoRemote:
+			fmt.Fprintln(&buf, output.String(filepath.Base(p)+":", err.Error()).Faint())
+		case err != nil:
+			failed.Store(true)
+			buf.Reset()
+			fmt.Fprintln(&buf, output.String("Failed", p+":", straightup.Scrub(err.Error())).Foreground(output.Color(palette.Error)))
+		}
+		pr.Print(i, &buf)
+	})
+	if failed.Load() {
+		os.Exit(ExitFailed)
+	}
+}
+
+// Entry of list: local state of repo
+type RepoInfo struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Git       bool   `json:"git"`
+	Branch    string `json:"branch,omitempty"` // empty if HEAD is detached
+	Head      string `json:"head,omitempty"`
+	RemoteURL string `json:"remote_url,omitempty"`
+	Tagged    bool   `json:"tagged"`               // has update marker
+	LegacyTag bool   `json:"legacy_tag,omitempty"` // marked by legacy Updated.At tag only
+	Error     string `json:"error,omitempty"`
+}
+
+// Describe repo p from its local state only, entries without .git are
+// reported with Git unset
+func ListGitRepo(p string) (info RepoInfo) {
+	info = RepoInfo{Name: filepath.Base(p), Path: p}
+	if _, err := os.Lstat(filepath.Join(p, ".git")); err != nil {
+		return
+	}
+	info.Git = true
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		info.Error = err.Error()
+		return
+	}
+	head, err := r.Head()
+	if err != nil {
+		info.Error = err.Error()
+		return
+	}
+	info.Head = head.Hash().String()
+	if head.Name().IsBranch() {
+		info.Branch = head.Name().Short()
+	}
+	if rr, err := straightup.UpstreamRemote(r, head); err == nil {
+		info.RemoteURL = straightup.Scrub(rr.Config().URLs[0])
+	}
+	if ref, err := straightup.MarkedCommit(r); err == nil {
+		info.Tagged, info.LegacyTag = true, ref.Name() != straightup.MarkerRef
+	}
+	return
+}
+
+// updstraight list [repo...]
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight list [repo...]")
+		fmt.Fprintln(fs.Output(), "List repos (all if none given) with their branch, origin and update marker, without network access.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	repos, excluded := SelectRepos(fs.Args())
+	if fs.NArg() == 0 {
+		// show also what is skipped by the update as not a git repo
+		dir, err := straightup.ExpandHome(cmp.Or(*reposDir, straightup.DefaultReposDir))
+		if err != nil {
+			fatal(err)
+		}
+		_, other, err := straightup.ScanReposDir(dir)
+		if err != nil {
+			fatal(err)
+		}
+		repos = append(repos, other...)
+		slices.Sort(repos)
+	}
+	infos := make([]RepoInfo, len(repos))
+	for i, p := range repos {
+		infos[i] = ListGitRepo(p)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(infos); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// align plain text first, escape sequences would break the widths
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tBRANCH\tREMOTE\tMARKER")
+	for _, v := range infos {
+		branch, origin, tag := v.Branch, v.RemoteURL, "-"
+		switch {
+		case !v.Git:
+			branch = "not a git repo"
+		case v.Error != "":
+			branch = v.Error
+		case branch == "":
+			branch = "detached at " + straightup.ShortHash(v.Head)
+		}
+		if origin == "" {
+			origin = "-"
+		}
+		switch {
+		case v.LegacyTag:
+			tag = straightup.TagName + " (legacy)"
+		case v.Tagged:
+			tag = straightup.MarkerRef.Short()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.Name, branch, origin, tag)
+	}
+	tw.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	fmt.Println(output.String(lines[0]).Bold())
+	for i, l := range lines[1:] {
+		s := output.String(l)
+		switch v := infos[i]; {
+		case !v.Git || v.Error != "":
+			s = s.Foreground(output.Color(palette.Error))
+		case v.Branch == "" || v.RemoteURL == "":
+			s = s.Foreground(output.Color(palette.Notice))
+		}
+		fmt.Println(s)
+	}
+	if excluded > 0 {
+		fmt.Println(output.String(strconv.Itoa(excluded), "repos skipped by exclusion").Faint())
+	}
+}
+
+// Entry of status: how far HEAD of repo is from its remote-tracking branch
+type RepoStatus struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Branch    string `json:"branch,omitempty"`
+	Ahead     int    `json:"ahead"`
+	Behind    int    `json:"behind"`
+	Truncated bool   `json:"truncated,omitempty"` // counts are cut by shallow fetch
+	Local     bool   `json:"local,omitempty"`     // no remotes to compare with
+	Error     string `json:"error,omitempty"`
+}
+
+// Compare HEAD of repo p with its remote-tracking branch, fetch the remote
+// first unless offline is set, the worktree and tags are not touched
+func StatusGitRepo(p string, offline bool) (st RepoStatus, err error) {
+	st = RepoStatus{Name: filepath.Base(p), Path: p}
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return
+	}
+	head, err := r.Head()
+	if err != nil {
+		return
+	}
+	rr, err := straightup.UpstreamRemote(r, head)
+	if err == straightup.ErrNoRemote {
+		st.Local, st.Branch = true, head.Name().Short()
+		return st, nil
+	}
+	if err != nil {
+		return
+	}
+	if !offline {
+		ctx, cancel := NetworkContext()
+		defer cancel()
+		if err = straightup.FetchGitChanges(ctx, rr, network()); err != nil {
+			return
+		}
+	}
+	branch := straightup.ConfiguredBranch(r, branches[st.Name], head)
+	st.Branch = branch.Short()
+	tip, err := straightup.RemoteTrackingRef(r, rr.Config().Name, branch)
+	if err != nil {
+		return
+	}
+	st.Ahead, st.Behind, st.Truncated, err = straightup.AheadBehind(r, head.Hash(), tip.Hash())
+	return
+}
+
+// updstraight status [--offline] [repo...]
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	offline := fs.Bool("offline", false, "do not fetch, compare with the last fetched remote branch")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight status [--offline] [repo...]")
+		fmt.Fprintln(fs.Output(), "Fetch repos (all if none given) and show how many commits HEAD is behind and ahead of the remote branch.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !*offline {
+		LockRun(context.Background()) // the fetch moves remote-tracking refs
+	}
+	repos, _ := SelectRepos(fs.Args())
+	statuses := make([]RepoStatus, len(repos))
+	var failed atomic.Bool
+	ForEachRepo(repos, func(i int, p string) {
+		var err error
+		if statuses[i], err = StatusGitRepo(p, *offline); err != nil {
+			statuses[i].Error = err.Error()
+			failed.Store(true)
+		}
+	})
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(statuses); err != nil {
+			fatal(err)
+		}
+	} else {
+		PrintStatus(statuses)
+	}
+	if failed.Load() {
+		os.Exit(ExitFailed)
+	}
+}
+
+// Print aligned table of ahead/behind counts, repos which are both ahead and
+// behind are highlighted: pull would merge there instead of fast-forward
+func PrintStatus(statuses []RepoStatus) {
+	// align plain text first, escape sequences would break the widths
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tBRANCH\tBEHIND\tAHEAD\tSTATE")
+	for _, v := range statuses {
+		behind, ahead := strconv.Itoa(v.Behind), strconv.Itoa(v.Ahead)
+		if v.Truncated {
+			behind, ahead = behind+"+", ahead+"+"
+		}
+		state := "up to date"
+		switch {
+		case v.Error != "":
+			behind, ahead, state = "-", "-", v.Error
+		case v.Local:
+			behind, ahead, state = "-", "-", "local"
+		case v.Behind > 0 && v.Ahead > 0:
+			state = "diverged"
+		case v.Behind > 0:
+			state = "behind"
+		case v.Ahead > 0:
+			state = "ahead"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.Name, v.Branch, behind, ahead, state)
+	}
+	tw.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	fmt.Println(output.String(lines[0]).Bold())
+	for i, l := range lines[1:] {
+		s := output.String(l)
+		switch v := statuses[i]; {
+		case v.Error != "":
+			s = s.Foreground(output.Color(palette.Error))
+		case v.Behind > 0 && v.Ahead > 0:
+			s = s.Foreground(output.Color(palette.Warning)).Bold()
+		case v.Behind > 0:
+			s = s.Foreground(output.Color(palette.Updated))
+		case v.Ahead > 0:
+			s = s.Foreground(output.Color(palette.Notice))
+		default:
+			s = s.Faint()
+		}
+		fmt.Println(s)
+	}
+}
+
+// Parse --since value: date, RFC 3339 time or duration back from now like 72h
+func ParseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.DateOnly, time.DateTime, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q: expected date like 2024-01-31, RFC 3339 time or duration like 72h", s)
+}
+
+// Render commits of repo p between update marker and HEAD, or since given
+// time if it's set, no network access; repo never updated fails with wrapped
+// straightup.ErrNoMarker
+func LogGitRepo(p string, since time.Time, buf *bytes.Buffer) (n int, err error) {
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return
+	}
+	head, err := r.Head()
+	if err != nil {
+		return
+	}
+
+	var (
+		l   string
+		rng = "since " + since.Format(time.DateTime)
+	)
+	if since.IsZero() {
+		tag, err := straightup.MarkedCommit(r)
+		if err == straightup.ErrNoMarker {
+			return 0, fmt.Errorf("%w, use --since DATE to show recent commits", err)
+		}
+		if err != nil {
+			return 0, err
+		}
+		rng = "since the last update " + straightup.ShortHash(tag.Hash().String())
+		l, err = straightup.GetGitLog(r, tag, head, &n, theme())
+	} else {
+		l, err = straightup.GetGitLogSince(r, head, since, &n, theme())
+	}
+	if err != nil || n == 0 {
+		return
+	}
+	fmt.Fprintln(buf,
+		output.String(filepath.Base(p)).Foreground(output.Color(palette.Notice)),
+		output.String(strconv.Itoa(n), "commits", rng).Foreground(output.Color(palette.Count)),
+	)
+	buf.WriteString(l)
+	return
+}
+
+// updstraight log [--since DATE] [--all] [repo...]
+func runLog(args []string) {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	all := fs.Bool("all", false, "show commits of all repos")
+	sinceFlag := fs.String("since", "", "show commits since date (2024-01-31), time or duration ago (72h) instead of since the last update")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight log [--since DATE] [--all | repo...]")
+		fmt.Fprintln(fs.Output(), "Show commits of repos merged by the last update, i.e. between update marker and HEAD, without network access.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if !*all && fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(ExitSetup)
+	}
+
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = ParseSince(*sinceFlag); err != nil {
+			fatal(err)
+		}
+	}
+
+	repos, _ := SelectRepos(fs.Args())
+	var failed bool
+	for _, p := range repos {
+		var buf bytes.Buffer
+		n, err := LogGitRepo(p, since, &buf)
+		switch {
+		case errors.Is(err, straightup.ErrNoMarker):
+			fmt.Println(output.String(filepath.Base(p)+":", err.Error()).Foreground(output.Color(palette.Notice)))
+		case err != nil:
+			failed = true
+			fmt.Println(output.String("Failed", p+":", straightup.Scrub(err.Error())).Foreground(output.Color(palette.Error)))
+		case n == 0 && !*all:
+			fmt.Println(output.String(filepath.Base(p), "has no new commits").Faint())
+		default:
+			buf.WriteTo(os.Stdout)
+		}
+	}
+	if failed {
+		os.Exit(ExitFailed)
+	}
+}
+
+// Set update marker of repo p to HEAD, or delete it if del is set, return
+// the old (zero if there was no marker) and new (zero if deleted) marked
+// commits
+func ResetUpdateMarker(p string, del bool) (old, new plumbing.Hash, err error) {
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return
+	}
+	tag, err := straightup.MarkedCommit(r)
+	switch err {
+	case nil:
+		old = tag.Hash()
+	case straightup.ErrNoMarker:
+		err = nil
+	default:
+		return
+	}
+	if del {
+		if !old.IsZero() {
+			err = straightup.DeleteUpdateMarker(r)
+		}
+		return
+	}
+	head, err := r.Head()
+	if err != nil {
+		return
+	}
+	if tag, err = straightup.SetUpdateMarker(r, head); err != nil {
+		return
+	}
+	return old, tag.Hash(), nil
+}
+
+// updstraight reset-tags [--delete] [repo...]
+func runResetTags(args []string) {
+	fs := flag.NewFlagSet("reset-tags", flag.ExitOnError)
+	del := fs.Bool("delete", false, "delete update marker instead of moving it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight reset-tags [--delete] [repo...]")
+		fmt.Fprintln(fs.Output(), "Move update marker of repos (all if none given) to the current HEAD, i.e. take the current state as baseline of the next update.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	LockRun(context.Background())
+	repos, _ := SelectRepos(fs.Args())
+
+	var failed bool
+	for _, p := range repos {
+		old, new, err := ResetUpdateMarker(p, *del)
+		switch {
+		case err != nil:
+			failed = true
+			fmt.Println(output.String("Failed", p+":", straightup.Scrub(err.Error())).Foreground(output.Color(palette.Error)))
+		case old == new:
+			if new.IsZero() {
+				fmt.Println(output.String(filepath.Base(p), "has no update marker").Faint())
+			} else {
+				fmt.Println(output.String(filepath.Base(p), "already at", new.String()[:6]).Faint())
+			}
+		case old.IsZero():
+			fmt.Println(
+				output.String("Created marker of", filepath.Base(p)).Foreground(output.Color(palette.Notice)),
+				output.String("at", new.String()[:6]).Foreground(output.Color(palette.Hash)),
+			)
+		case new.IsZero():
+			fmt.Println(
+				output.String("Deleted marker of", filepath.Base(p)).Foreground(output.Color(palette.Notice)),
+				output.String("was", old.String()[:6]).Foreground(output.Color(palette.Hash)),
+			)
+		default:
+			fmt.Println(
+				output.String("Moved marker of", filepath.Base(p)).Foreground(output.Color(palette.Notice)),
+				output.String(old.String()[:6], "->", new.String()[:6]).Foreground(output.Color(palette.Hash)),
+			)
+		}
+	}
+	if failed {
+		os.Exit(ExitFailed)
+	}
+}
+
+// Lockfile of repo states written by freeze
+type Lock struct {
+	Created time.Time   `json:"created"`
+	Repos   []LockEntry `json:"repos"`
+}
+
+// State of one repo in lockfile
+type LockEntry struct {
+	Name      string `json:"name"`
+	RemoteURL string `json:"remote_url,omitempty"`
+	Branch    string `json:"branch,omitempty"`
+	Head      string `json:"head,omitempty"`
+	Note      string `json:"note,omitempty"` // why the repo cannot be reproduced as is
+}
+
+// Collect HEAD of repos sorted by name, repos which are not git repos, have
+// detached HEAD or no remote are annotated
+func FreezeRepos(repos []string) Lock {
+	lock := Lock{Created: time.Now().UTC().Truncate(time.Second), Repos: make([]LockEntry, 0, len(repos))}
+	for _, p := range repos {
+		info := ListGitRepo(p)
+		e := LockEntry{Name: info.Name, RemoteURL: info.RemoteURL, Branch: info.Branch, Head: info.Head}
+		switch {
+		case !info.Git:
+			e.Note = "not a git repo"
+		case info.Error != "":
+			e.Note = info.Error
+		case info.RemoteURL == "" && info.Branch == "":
+			e.Note = "no remote, detached HEAD"
+		case info.RemoteURL == "":
+			e.Note = "no remote"
+		case info.Branch == "":
+			e.Note = "detached HEAD"
+		}
+		lock.Repos = append(lock.Repos, e)
+	}
+	slices.SortFunc(lock.Repos, func(a, b LockEntry) int { return cmp.Compare(a.Name, b.Name) })
+	return lock
+}
+
+// Write lock as straight.el versions alist, details are written as comments
+func WriteLockElisp(w io.Writer, lock Lock) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, ";; Written by updstraight freeze at %s\n", lock.Created.Format(time.RFC3339))
+	opened := false
+	for _, e := range lock.Repos {
+		if e.Head == "" {
+			fmt.Fprintf(&b, " ;; %q %s\n", e.Name, e.Note)
+			continue
+		}
+		open := " "
+		if !opened {
+			open, opened = "(", true
+		}
+		fmt.Fprintf(&b, "%s(%q . %q)", open, e.Name, e.Head)
+		comment := strings.Join(slices.DeleteFunc([]string{e.Branch, e.RemoteURL, e.Note}, func(s string) bool { return s == "" }), " ")
+		if comment != "" {
+			fmt.Fprintf(&b, " ;; %s", comment)
+		}
+		b.WriteString("\n")
+	}
+	if !opened {
+		b.WriteString("(")
+	}
+	b.WriteString(")\n:gamma\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// updstraight freeze [--out FILE] [--format json|el] [repo...]
+func runFreeze(args []string) {
+	fs := flag.NewFlagSet("freeze", flag.ExitOnError)
+	out := fs.String("out", "-", "lockfile to write, - for stdout")
+	format := fs.String("format", "", "lockfile format: json or el (straight.el versions alist), default by --out extension, json otherwise")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight freeze [--out FILE] [--format json|el] [repo...]")
+		fmt.Fprintln(fs.Output(), "Write lockfile with remote URL, branch and HEAD commit of repos (all if none given).")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format == "" {
+		*format = "json"
+		if filepath.Ext(*out) == ".el" {
+			*format = "el"
+		}
+	}
+
+	repos, _ := SelectRepos(fs.Args())
+	lock := FreezeRepos(repos)
+
+	var buf bytes.Buffer
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(lock); err != nil {
+			fatal(err)
+		}
+	case "el":
+		if err := WriteLockElisp(&buf, lock); err != nil {
+			fatal(err)
+		}
+	default:
+		fatalf("unknown lockfile format %q, expected json or el", *format)
+	}
+
+	if *out == "-" {
+		buf.WriteTo(os.Stdout)
+	} else if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		fatal(err)
+	}
+	for _, e := range lock.Repos {
+		if e.Note != "" {
+			warn("warning:", e.Name+":", e.Note)
+		}
+	}
+}
+
+// Read lockfile written by freeze, either JSON or straight.el versions alist
+func ReadLock(p string) (lock Lock, err error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return
+	}
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		err = json.Unmarshal(b, &lock)
+		return
+	}
+
+	// one ("name" . "hash") pair per line, comments are ignored
+	for i, l := range strings.Split(string(b), "\n") {
+		l, _, _ = strings.Cut(l, ";")
+		l = strings.Trim(strings.TrimSpace(l), "()")
+		if l == "" || l == ":gamma" {
+			continue
+		}
+		name, head, ok := strings.Cut(l, " . ")
+		if !ok {
+			return lock, fmt.Errorf("%s:%d: expected (\"name\" . \"hash\"), got %q", p, i+1, l)
+		}
+		var e LockEntry
+		if e.Name, err = strconv.Unquote(strings.TrimSpace(name)); err != nil {
+			return lock, fmt.Errorf("%s:%d: %w", p, i+1, err)
+		}
+		if e.Head, err = strconv.Unquote(strings.TrimSpace(head)); err != nil {
+			return lock, fmt.Errorf("%s:%d: %w", p, i+1, err)
+		}
+		lock.Repos = append(lock.Repos, e)
+	}
+	return
+}
+
+// Reset repo p hard to the commit of lock entry, fetch it if it's missing,
+// then set update marker to it; return the old and new HEAD hashes
+func RestoreGitRepo(p string, e LockEntry, force bool) (old, new plumbing.Hash, err error) {
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return
+	}
+	head, err := r.Head()
+	if err != nil {
+		return
+	}
+	old, new = head.Hash(), plumbing.NewHash(e.Head)
+
+	if _, err = r.CommitObject(new); err == plumbing.ErrObjectNotFound {
+		var rr *git.Remote
+		if rr, err = straightup.UpstreamRemote(r, head); err != nil {
+			return
+		}
+		ctx, cancel := NetworkContext()
+		defer cancel()
+		if err = straightup.FetchGitChanges(ctx, rr, network()); err != nil {
+			return
+		}
+		if _, err = r.CommitObject(new); err == plumbing.ErrObjectNotFound {
+			err = fmt.Errorf("commit %s is not found in %s", e.Head, rr.Config().Name)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return
+	}
+	if !force {
+		var files []string
+		if files, err = straightup.DirtyFiles(w); err != nil {
+			return
+		}
+		if len(files) > 0 {
+			err = fmt.Errorf("%d files have uncommitted changes, use --force to discard them", len(files))
+			return
+		}
+	}
+	if b := plumbing.NewBranchReferenceName(e.Branch); e.Branch != "" && b != head.Name() {
+		if _, err = r.Reference(b, false); err == nil {
+			err = w.Checkout(&git.CheckoutOptions{Branch: b, Force: true})
+		}
+		if err != nil {
+			err = fmt.Errorf("checkout %s: %w", e.Branch, err)
+			return
+		}
+	}
+	if err = w.Reset(&git.ResetOptions{Commit: new, Mode: git.HardReset}); err != nil {
+		return
+	}
+	_, err = straightup.SetUpdateMarker(r, plumbing.NewHashReference(plumbing.HEAD, new))
+	return
+}
+
+// updstraight restore [--force] versions.lock [repo...]
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "discard uncommitted changes of repos")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight restore [--force] versions.lock [repo...]")
+		fmt.Fprintln(fs.Output(), "Reset repos (all if none given) hard to commits of lockfile written by freeze and move their update marker there.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(ExitSetup)
+	}
+
+	lock, err := ReadLock(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	LockRun(context.Background())
+	entries := make(map[string]LockEntry, len(lock.Repos))
+	for _, e := range lock.Repos {
+		if e.Head != "" {
+			entries[e.Name] = e
+		}
+	}
+
+	repos, _ := SelectRepos(fs.Args()[1:])
+	local := make(map[string]bool, len(repos))
+	var (
+		failed  bool
+		changed []string
+	)
+	for _, p := range repos {
+		name := filepath.Base(p)
+		local[name] = true
+		e, ok := entries[name]
+		if !ok {
+			warn("warning:", name, "is not in the lockfile")
+			continue
+		}
+		old, new, err := RestoreGitRepo(p, e, *force)
+		switch {
+		case err != nil:
+			failed = true
+			fmt.Println(output.String("Failed", p+":", straightup.Scrub(err.Error())).Foreground(output.Color(palette.Error)))
+		case old == new:
+			fmt.Println(output.String(name, "already at", new.String()[:6]).Faint())
+		default:
+			changed = append(changed, name)
+			fmt.Println(
+				output.String("Restored", name).Foreground(output.Color(palette.Notice)),
+				output.String(old.String()[:6], "->", new.String()[:6]).Foreground(output.Color(palette.Hash)),
+			)
+		}
+	}
+	if fs.NArg() == 1 {
+		for _, e := range lock.Repos {
+			if !local[e.Name] {
+				warn("warning:", e.Name, "is in the lockfile, but not cloned locally")
+			}
+		}
+	}
+
+	if err := RestartEmacsIfNeeded(changed); err != nil {
+		log.Print(err)
+		os.Exit(ExitRestart)
+	}
+	if failed {
+		os.Exit(ExitFailed)
+	}
+}
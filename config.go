@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Keys of config file, command line flags override them
+type Config struct {
+	Dir          string            `toml:"dir"`
+	Manager      string            `toml:"manager"`
+	DoomSync     bool              `toml:"doom_sync"`
+	Rebuild      bool              `toml:"rebuild"`
+	IgnoreLock   bool              `toml:"ignore_lockfile"`
+	WriteLock    string            `toml:"write_lockfile"` // true or path
+	Only         []string          `toml:"only"`
+	Exclude      []string          `toml:"exclude"`
+	Branches     map[string]string `toml:"branches"`
+	Jobs         int               `toml:"jobs"`
+	Timeout      time.Duration     `toml:"timeout"`
+	MinAge       time.Duration     `toml:"min_age"`
+	LockWait     time.Duration     `toml:"lock_wait"`
+	WatchIdle    time.Duration     `toml:"watch_idle"`
+	Depth        int               `toml:"depth"`
+	Retries      int               `toml:"retries"`
+	RestartCmd   []string          `toml:"restart_cmd"`
+	RestartRepos []string          `toml:"restart_repos"`
+	PostUpdate   string            `toml:"post_update"`
+	Hooks        map[string]Hooks  `toml:"hooks"`
+	NoRestart    bool              `toml:"no_restart"`
+	StartDaemon  bool              `toml:"start_daemon"`
+	Autostash    bool              `toml:"autostash"`
+	SwitchDef    bool              `toml:"switch_default"`
+	Notify       bool              `toml:"notify"`
+	MaxLog       int               `toml:"max_log"`
+	Proxy        string            `toml:"proxy"`
+	TokenHosts   []string          `toml:"token_hosts"`
+	SocketName   string            `toml:"socket_name"`
+	ServerFile   string            `toml:"server_file"`
+	Color        string            `toml:"color"`
+	NoLinks      bool              `toml:"no_hyperlinks"`
+	Theme        ThemeConfig       `toml:"theme"`
+	Template     string            `toml:"template"`
+	Rebase       bool              `toml:"rebase"`
+	RebaseRepos  []string          `toml:"rebase_repos"`
+	Feed         bool              `toml:"feed"`
+	GroupBy      string            `toml:"group_by"`
+	Breaking     []string          `toml:"breaking_patterns"`
+	Highlight    []string          `toml:"highlight"`
+	Report       string            `toml:"report"`
+	ReportAppend bool              `toml:"report_append"`
+	Webhook      string            `toml:"webhook"`
+	Open         int               `toml:"open"`
+	WebhookTpl   string            `toml:"webhook_template"`
+}
+
+// Theme table of config file: name of preset and its colors to override,
+// keys of colors are the names of palette fields in lower case, e.g. hash
+type ThemeConfig struct {
+	Name string `toml:"name"`
+	straightup.Palette
+}
+
+// Read config file p, unknown keys are rejected so typos do not pass silently
+func ReadConfig(p string) (cfg Config, md toml.MetaData, err error) {
+	if md, err = toml.DecodeFile(p, &cfg); err != nil {
+		return cfg, md, fmt.Errorf("%s: %w", p, err)
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		unknown := make([]string, len(keys))
+		for i, k := range keys {
+			unknown[i] = k.String()
+		}
+		err = fmt.Errorf("%s: unknown keys: %s", p, strings.Join(unknown, ", "))
+	}
+	return
+}
+
+// Apply values of config file p to the flags which are not given on command
+// line, if p is empty config.toml of config directory is used if exists
+func ApplyConfig(p string) error {
+	explicit := p != ""
+	if !explicit {
+		cfgDir, err := ConfigDir()
+		if err != nil {
+			return err
+		}
+		p = filepath.Join(cfgDir, "config.toml")
+	}
+	p, err := straightup.ExpandHome(p)
+	if err != nil {
+		return err
+	}
+	cfg, md, err := ReadConfig(p)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	apply := func(key string, f func(), flags ...string) {
+		if !md.IsDefined(key) {
+			return
+		}
+		for _, v := range flags {
+			if given[v] {
+				return
+			}
+		}
+		f()
+	}
+
+	apply("dir", func() { *reposDir = cfg.Dir }, "dir")
+	apply("manager", func() { *managerFlag = cfg.Manager }, "manager")
+	apply("doom_sync", func() { *doomSync = cfg.DoomSync }, "doom-sync")
+	apply("rebuild", func() { *rebuild = cfg.Rebuild }, "rebuild")
+	apply("ignore_lockfile", func() { *ignoreLockfile = cfg.IgnoreLock }, "ignore-lockfile")
+	apply("write_lockfile", func() { writeLockfile.Set(cfg.WriteLock) }, "write-lockfile")
+	apply("only", func() { only = cfg.Only }, "only")
+	apply("exclude", func() { exclude = cfg.Exclude }, "exclude")
+	apply("branches", func() { branches = cfg.Branches }, "branch")
+	apply("jobs", func() { *jobs = cfg.Jobs }, "jobs", "j")
+	apply("timeout", func() { *timeout = cfg.Timeout }, "timeout")
+	apply("min_age", func() { *minAge = cfg.MinAge }, "min-age")
+	apply("lock_wait", func() { *lockWait = cfg.LockWait }, "lock-wait")
+	apply("watch_idle", func() { *watchIdle = cfg.WatchIdle }, "watch-idle")
+	apply("depth", func() { *depth = cfg.Depth }, "depth")
+	apply("proxy", func() { *proxyURL = cfg.Proxy }, "proxy")
+	apply("token_hosts", func() { tokenHosts = cfg.TokenHosts })
+	apply("retries", func() { *retries = cfg.Retries }, "retries")
+	apply("restart_cmd", func() { restartCmd = cfg.RestartCmd }, "restart-cmd")
+	apply("restart_repos", func() { restartRepos = cfg.RestartRepos })
+	apply("post_update", func() { postUpdate = cfg.PostUpdate })
+	apply("hooks", func() { hooks = cfg.Hooks })
+	apply("socket_name", func() { *socketName = cfg.SocketName }, "socket-name")
+	apply("server_file", func() { *serverFile = cfg.ServerFile }, "server-file")
+	apply("autostash", func() { *autostash = cfg.Autostash }, "autostash")
+	apply("switch_default", func() { *switchDefault = cfg.SwitchDef }, "switch-default")
+	apply("notify", func() { *notify = cfg.Notify }, "notify")
+	apply("no_restart", func() { *noRestart = cfg.NoRestart }, "no-restart")
+	apply("start_daemon", func() { *startDaemon = cfg.StartDaemon }, "start-daemon")
+	apply("color", func() { *colorMode = cfg.Color }, "color")
+	apply("no_hyperlinks", func() { *noHyperlinks = cfg.NoLinks }, "no-hyperlinks")
+	apply("max_log", func() { *maxLog = cfg.MaxLog }, "max-log")
+	apply("rebase", func() { *rebase = cfg.Rebase }, "rebase")
+	apply("rebase_repos", func() { rebaseRepos = cfg.RebaseRepos })
+	apply("template", func() { *templatePath = cfg.Template }, "template")
+	apply("breaking_patterns", func() { breakingFlags = cfg.Breaking }, "breaking-pattern")
+	apply("highlight", func() { highlightFlags = cfg.Highlight }, "highlight")
+	apply("feed", func() { *feed = cfg.Feed }, "feed")
+	apply("open", func() { *openMin = cfg.Open }, "open")
+	apply("group_by", func() { *groupBy = cfg.GroupBy }, "group-by")
+	apply("theme", func() {
+		if cfg.Theme.Name != "" && !given["theme"] {
+			*themeName = cfg.Theme.Name
+		}
+		themeColors = cfg.Theme.Palette
+	})
+	apply("report", func() { *reportPath = cfg.Report }, "report")
+	apply("report_append", func() { *reportAppend = cfg.ReportAppend }, "report-append")
+	apply("webhook", func() { *webhook = cfg.Webhook }, "webhook")
+	apply("webhook_template", func() { *webhookTemplate = cfg.WebhookTpl }, "webhook-template")
+	return nil
+}
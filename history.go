@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Directory of updstraight state files like run history
+func StateDir() (string, error) {
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return filepath.Join(d, "updstraight"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "updstraight"), nil
+}
+
+// Path of run history file of state directory
+func HistoryPath() (string, error) {
+	d, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "history.jsonl"), nil
+}
+
+// Record of a run in history file, a JSON document per line
+type HistoryRecord struct {
+	Time        time.Time           `json:"time"`
+	Duration    time.Duration       `json:"duration_ns"`
+	Repos       int                 `json:"repos"` // processed by the run
+	Restarted   bool                `json:"restarted"`
+	Interrupted bool                `json:"interrupted,omitempty"`
+	Undo        *time.Time          `json:"undo,omitempty"` // time of the run undone by this one
+	Changes     []straightup.Result `json:"changes"`        // updated and failed repos, reset ones for undo
+}
+
+// History record of the run started at start by reports of repos
+func NewHistoryRecord(reports []straightup.Result, start time.Time) HistoryRecord {
+	rec := HistoryRecord{
+		Time:        start,
+		Duration:    time.Since(start),
+		Repos:       len(reports),
+		Restarted:   emacsRestarted,
+		Interrupted: interrupted.Load(),
+		Changes:     []straightup.Result{},
+	}
+	for _, v := range reports {
+		switch v.Status {
+		case straightup.StatusUpdated, straightup.StatusForced, straightup.StatusFailed, straightup.StatusPartial:
+			rec.Changes = append(rec.Changes, v)
+		}
+	}
+	return rec
+}
+
+// Totals of history record: updated repos, their new commits and failed repos
+func (rec HistoryRecord) Totals() (updated, commits, failed int) {
+	for _, v := range rec.Changes {
+		if v.Status == straightup.StatusFailed {
+			failed++
+			continue
+		}
+		if v.Status == straightup.StatusPartial {
+			failed++
+		}
+		if v.NewCommits > 0 {
+			updated++
+			commits += v.NewCommits
+		}
+	}
+	return
+}
+
+// Append record to history file p under exclusive lock of the file, so
+// concurrent runs do not mix their lines
+func AppendHistory(p string, rec HistoryRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = lockFile(f); err == nil {
+		_, err = f.Write(append(b, '\n'))
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Read records of history file p, oldest first; no file means no history
+func ReadHistory(p string) ([]HistoryRecord, error) {
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the last line is empty, or being written right now if it's not
+	// terminated by newline yet
+	lines := bytes.Split(b, []byte("\n"))
+	var l []HistoryRecord
+	for i, line := range lines[:len(lines)-1] {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec HistoryRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return l, fmt.Errorf("%s:%d: %w", p, i+1, err)
+		}
+		l = append(l, rec)
+	}
+	return l, nil
+}
+
+// Append the run to history file, dry runs are not recorded as nothing is
+// updated by them
+func SaveHistory(reports []straightup.Result, start time.Time) {
+	if *dryRun {
+		return
+	}
+	p, err := HistoryPath()
+	if err == nil {
+		err = AppendHistory(p, NewHistoryRecord(reports, start))
+	}
+	if err != nil {
+		warn("cannot write history:", err.Error())
+	}
+}
+
+// First line of commit message
+func subject(msg string) string {
+	s, _, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	return s
+}
+
+// updstraight history [-n N] [--repo name] [--last]
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("n", 20, "show at most N recent runs or updates of --repo, 0 for all")
+	repo := fs.String("repo", "", "show update timeline of repo")
+	last := fs.Bool("last", false, "render commit logs of the most recent run again, or of the last update of --repo")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight history [-n N] [--repo name] [--last]")
+		fmt.Fprintln(fs.Output(), "Show recorded runs from history file, repos are not touched.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(ExitSetup)
+	}
+
+	p, err := HistoryPath()
+	if err != nil {
+		fatal(err)
+	}
+	records, err := ReadHistory(p)
+	if err != nil {
+		fatal(err)
+	}
+	if len(records) == 0 {
+		fmt.Println(output.String("no runs recorded in", p).Faint())
+		return
+	}
+	slices.Reverse(records) // newest first
+
+	type update struct {
+		at  time.Time
+		rep straightup.Result
+	}
+	var updates []update
+	for _, rec := range records {
+		if *last && rec.Undo != nil {
+			continue // nothing to render
+		}
+		for _, v := range rec.Changes {
+			if *repo == "" || v.Name == *repo {
+				updates = append(updates, update{rec.Time, v})
+			}
+		}
+		if *last && *repo == "" {
+			break // changes of the most recent run only
+		}
+	}
+
+	switch {
+	case *last:
+		if len(updates) == 0 {
+			if *repo == "" {
+				fmt.Println(output.String("nothing updated by the run of", records[0].Time.Format("2006-01-02 15:04")).Faint())
+			} else {
+				fmt.Println(output.String("no updates of", *repo, "recorded").Faint())
+			}
+			return
+		}
+		if *repo != "" {
+			updates = updates[:1]
+		}
+		fmt.Println(output.String("Run of", updates[0].at.Format("2006-01-02 15:04")).Bold())
+		for _, v := range updates {
+			v.rep.RestoreLog()
+			if err := straightup.RenderResult(os.Stdout, v.rep, theme()); err != nil {
+				fatal(err)
+			}
+			if v.rep.Status == straightup.StatusFailed || v.rep.Status == straightup.StatusPartial {
+				fmt.Println(output.String(v.rep.Name, v.rep.Status+":", v.rep.Error).Foreground(output.Color(palette.Error)))
+			}
+		}
+	case *repo != "":
+		if len(updates) == 0 {
+			fmt.Println(output.String("no updates of", *repo, "recorded").Faint())
+			return
+		}
+		if *limit > 0 && len(updates) > *limit {
+			updates = updates[:*limit]
+		}
+		for _, v := range updates {
+			at := output.String(v.at.Format("2006-01-02 15:04")).Foreground(output.Color(palette.Date))
+			switch v.rep.Status {
+			case straightup.StatusFailed:
+				fmt.Println(at, output.String("failed:", v.rep.Error).Foreground(output.Color(palette.Error)))
+				continue
+			case statusUndone:
+				fmt.Println(at, output.String("undone", straightup.ShortHash(v.rep.PreviousHash)+".."+straightup.ShortHash(v.rep.NewHash)).Foreground(output.Color(palette.Notice)))
+				continue
+			}
+			fmt.Println(at,
+				output.String(strconv.Itoa(v.rep.NewCommits), "new commits").Foreground(output.Color(palette.Count)),
+				output.String(straightup.ShortHash(v.rep.PreviousHash)+".."+straightup.ShortHash(v.rep.NewHash)).Faint())
+			for _, c := range v.rep.Commits {
+				fmt.Println("\t"+straightup.ShortHash(c.Hash), subject(c.Message))
+			}
+		}
+	default:
+		if *limit > 0 && len(records) > *limit {
+			records = records[:*limit]
+		}
+		for _, rec := range records {
+			at := output.String(rec.Time.Format("2006-01-02 15:04")).Foreground(output.Color(palette.Date))
+			if rec.Undo != nil {
+				names := make([]string, len(rec.Changes))
+				for i, v := range rec.Changes {
+					names[i] = v.Name
+				}
+				fmt.Println(at, output.String("undo of the run of", rec.Undo.Format("2006-01-02 15:04")).Foreground(output.Color(palette.Notice)),
+					output.String("("+strings.Join(names, ", ")+")").Faint())
+				continue
+			}
+			updated, commits, failed := rec.Totals()
+			line := []any{at, fmt.Sprintf("%d of %d repos updated, %d commits", updated, rec.Repos, commits)}
+			if failed > 0 {
+				line = append(line, output.String(strconv.Itoa(failed), "failed").Foreground(output.Color(palette.Error)))
+			}
+			if rec.Restarted {
+				line = append(line, output.String("Emacs restarted").Foreground(output.Color(palette.Warning)))
+			}
+			if rec.Interrupted {
+				line = append(line, output.String("interrupted").Foreground(output.Color(palette.Notice)))
+			}
+			var names []string
+			for _, v := range rec.Changes {
+				if v.NewCommits > 0 {
+					names = append(names, v.Name)
+				}
+			}
+			if len(names) > 0 {
+				line = append(line, output.String("("+strings.Join(names, ", ")+")").Faint())
+			}
+			fmt.Println(line...)
+		}
+	}
+}
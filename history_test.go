@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+func TestAppendHistory(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "state/history.jsonl")
+	at := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	rec := NewHistoryRecord([]straightup.Result{
+		{Name: "org", Status: straightup.StatusUpdated, NewCommits: 2, Commits: []straightup.CommitInfo{
+			{Hash: strings.Repeat("a", 40), Author: "Ihor <ihor@org>", Date: at, Message: "Fix agenda\n\nDetails"},
+			{Hash: strings.Repeat("b", 40), Author: "Ihor <ihor@org>", Date: at, Message: "Add feature"},
+		}},
+		{Name: "magit", Status: straightup.StatusFailed, Error: "connection refused"},
+		{Name: "dash.el", Status: straightup.StatusUpToDate},
+	}, at)
+
+	// concurrent runs append whole lines
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := AppendHistory(p, rec); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// the line being written by another run is not read
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2026-`)
+	f.Close()
+
+	l, err := ReadHistory(p)
+	if err != nil || len(l) != 4 {
+		t.Fatalf("history = %d records, %v, want 4", len(l), err)
+	}
+	if updated, commits, failed := l[3].Totals(); updated != 1 || commits != 2 || failed != 1 || l[3].Repos != 3 || !l[3].Time.Equal(at) {
+		t.Errorf("totals = %d %d %d of %d repos, want 1 updated, 2 commits, 1 failed of 3", updated, commits, failed, l[3].Repos)
+	}
+
+	// the stored commits are rendered again
+	rep := l[0].Changes[0]
+	rep.RestoreLog()
+	var buf bytes.Buffer
+	if err = straightup.RenderResult(&buf, rep, straightup.Theme{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); !strings.Contains(s, "Fix agenda") || !strings.Contains(s, "Ihor") || !strings.Contains(s, "aaaaaa") {
+		t.Errorf("rendered result of history = %q", s)
+	}
+
+	if l, err = ReadHistory(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || l != nil {
+		t.Errorf("missing history = %v, %v", l, err)
+	}
+}
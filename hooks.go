@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Hook commands of repo, config key hooks.<repo>
+type Hooks struct {
+	PreUpdate  string `toml:"pre_update"`  // run before new commits are merged
+	PostUpdate string `toml:"post_update"` // run after new commits are merged
+}
+
+// Environment of hook commands of repo updated from old to new commit
+func hookEnv(name, old, new string, commits int) []string {
+	return []string{
+		"UPDSTRAIGHT_REPO=" + name,
+		"UPDSTRAIGHT_OLD_HASH=" + old,
+		"UPDSTRAIGHT_NEW_HASH=" + new,
+		"UPDSTRAIGHT_COMMITS=" + strconv.Itoa(commits),
+	}
+}
+
+// Run hook command cmd of kind by run
+func runHook(run Runner, kind, cmd string) error {
+	args, err := SplitCommand(cmd)
+	if err == nil {
+		err = run.Run(args[0], args[1:]...)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook %q failed: %w", kind, cmd, err)
+	}
+	return nil
+}
+
+// Run pre_update hook cmd of repo before new commits are merged, hook output
+// goes to out
+func preUpdateHook(cmd string, out io.Writer) func(string, plumbing.Hash, plumbing.Hash, int) error {
+	return func(p string, head, upstream plumbing.Hash, commits int) error {
+		run := execRunner{dir: p, env: hookEnv(filepath.Base(p), head.String(), upstream.String(), commits), w: out}
+		return runHook(run, "pre_update", cmd)
+	}
+}
+
+// Run post_update hooks, the global one and the one of repo, if the update
+// has merged new commits; failed hook turns the result into partial one
+func postUpdateHooks(rep *straightup.Result, out io.Writer) error {
+	if rep.NewCommits == 0 || (rep.Status != straightup.StatusUpdated && rep.Status != straightup.StatusForced) {
+		return nil
+	}
+	run := execRunner{dir: rep.Path, env: hookEnv(rep.Name, rep.PreviousHash, rep.NewHash, rep.NewCommits), w: out}
+	for _, cmd := range []string{postUpdate, hooks[rep.Name].PostUpdate} {
+		if cmd == "" {
+			continue
+		}
+		if err := runHook(run, "post_update", cmd); err != nil {
+			rep.Status, rep.Error = straightup.StatusPartial, straightup.Scrub(err.Error())
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+func TestPostUpdateHooks(t *testing.T) {
+	oldPost, oldHooks := postUpdate, hooks
+	t.Cleanup(func() { postUpdate, hooks = oldPost, oldHooks })
+	dir := t.TempDir()
+	postUpdate = `sh -c "echo $UPDSTRAIGHT_REPO $UPDSTRAIGHT_OLD_HASH $UPDSTRAIGHT_NEW_HASH $UPDSTRAIGHT_COMMITS >> hook.out"`
+	hooks = map[string]Hooks{"vterm": {PostUpdate: "false"}}
+
+	rep := straightup.Result{Name: "magit", Path: dir, Status: straightup.StatusUpdated, PreviousHash: "aaa", NewHash: "bbb", NewCommits: 2}
+	if err := postUpdateHooks(&rep, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	// nothing is merged by dry run
+	pending := straightup.Result{Name: "magit", Path: dir, Status: straightup.StatusPending, NewCommits: 1}
+	if err := postUpdateHooks(&pending, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "hook.out"))
+	if err != nil || string(b) != "magit aaa bbb 2\n" {
+		t.Errorf("hook output = %q, %v, want the only run of updated repo", b, err)
+	}
+
+	rep = straightup.Result{Name: "vterm", Path: dir, Status: straightup.StatusUpdated, NewCommits: 1}
+	if err := postUpdateHooks(&rep, io.Discard); err == nil || rep.Status != straightup.StatusPartial || rep.Error == "" {
+		t.Errorf("failed hook = %v, status %s %q, want partial with error", err, rep.Status, rep.Error)
+	}
+}
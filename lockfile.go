@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Value of --write-lockfile flag: empty - off, true - lockfile of repos
+// directory, otherwise path of the file
+type lockfileFlag string
+
+func (f *lockfileFlag) String() string {
+	return string(*f)
+}
+
+func (f *lockfileFlag) Set(v string) error {
+	if v == "false" {
+		v = ""
+	}
+	*f = lockfileFlag(v)
+	return nil
+}
+
+// Allow plain --write-lockfile without path
+func (f *lockfileFlag) IsBoolFlag() bool {
+	return true
+}
+
+// Path of version lockfile to write, the one of --dir by default
+func (f lockfileFlag) Path() (string, error) {
+	if f == "true" {
+		return straightup.LockfilePath(*reposDir), nil
+	}
+	return straightup.ExpandHome(string(f))
+}
+
+// Commit to record in version lockfile for repo successfully processed by the
+// run, false for failed and skipped repos
+func LockedHash(v straightup.Result) (string, bool) {
+	switch v.Status {
+	case straightup.StatusUpdated, straightup.StatusUpToDate, straightup.StatusForced:
+		return v.NewHash, v.NewHash != ""
+	case straightup.StatusPinned, straightup.StatusDetached:
+		return v.PreviousHash, v.PreviousHash != "" // HEAD is not moved
+	}
+	return "", false
+}
+
+// Write version lockfile p with HEAD commits of repos successfully processed
+// by the run: entries of the previous file are replaced by them, the ones of
+// repos not in the run are kept, the ones of failed and skipped repos are
+// dropped; the previous file is backed up to p.bak
+func WriteLockfile(p string, reports []straightup.Result) error {
+	old, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	pins := make(map[string]string)
+	if len(old) > 0 {
+		if pins, err = straightup.ParseLockfile(p, string(old)); err != nil {
+			return err
+		}
+	}
+	for _, v := range reports {
+		delete(pins, v.Name)
+		if h, ok := LockedHash(v); ok {
+			pins[v.Name] = h
+		}
+	}
+
+	if len(old) > 0 {
+		if err = WriteFileAtomic(p+".bak", old, false); err != nil {
+			return fmt.Errorf("back up lockfile: %w", err)
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(p, straightup.FormatLockfile(pins), false)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+func TestWriteLockfile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "versions", "default.el")
+	reports := []straightup.Result{
+		{Name: "magit", Status: straightup.StatusUpdated, PreviousHash: "111", NewHash: "222"},
+		{Name: "dash", Status: straightup.StatusUpToDate, PreviousHash: "333", NewHash: "333"},
+		{Name: "evil", Status: straightup.StatusPinned, PreviousHash: "444", Pinned: "444"},
+		{Name: "org", Status: straightup.StatusFailed, PreviousHash: "555"},
+	}
+	if err := WriteLockfile(p, reports); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p + ".bak"); err == nil {
+		t.Error("backup is created, but there was no lockfile")
+	}
+
+	// entries of repos not in the run are kept, the failed ones are dropped
+	reports = []straightup.Result{
+		{Name: "magit", Status: straightup.StatusUpdated, PreviousHash: "222", NewHash: "666"},
+		{Name: "evil", Status: straightup.StatusDirty, PreviousHash: "444"},
+	}
+	if err := WriteLockfile(p, reports); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins, err := straightup.ParseLockfile(p, string(b))
+	if want := map[string]string{"magit": "666", "dash": "333"}; err != nil || !reflect.DeepEqual(pins, want) {
+		t.Errorf("lockfile pins %v, %v, want %v", pins, err, want)
+	}
+	bak, err := os.ReadFile(p + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pins, err = straightup.ParseLockfile(p, string(bak)); err != nil || pins["evil"] != "444" || pins["magit"] != "222" {
+		t.Errorf("backup has pins %v, %v, want the previous ones", pins, err)
+	}
+}
@@ -1,211 +1,1694 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
 
 	"github.com/muesli/termenv"
+	"golang.org/x/term"
+
+	"github.com/1buran/updstraight/pkg/straightup"
 )
 
+// Exit codes of the run, listed by --help
 const (
-	TagName = "Updated.At"
+	ExitFailed      = 1   // some repos have failed
+	ExitSetup       = 2   // fatal setup error: bad flags or config, missing repos directory
+	ExitRestart     = 3   // Emacs restart has failed, the repos are updated
+	ExitUpdated     = 4   // updates are applied, with --exit-code only
+	ExitLocked      = 5   // another run holds the run lock, past --lock-wait
+	ExitBehind      = 10  // some repos have pending updates, with --check
+	ExitInterrupted = 130 // interrupted by SIGINT or SIGTERM
 )
 
+// Time given to repos in progress to finish after interruption
+const interruptGrace = 10 * time.Second
+
 var (
 	output = termenv.NewOutput(os.Stdout)
+
+	configPath      = flag.String("config", "", "config file (default ~/.config/updstraight/config.toml if exists)")
+	dryRun          = flag.Bool("dry-run", false, "fetch and show pending commits, but do not merge or move the update marker")
+	reposDir        = flag.String("dir", "", "repos directory (default ~/.emacs.d/straight/repos or ~/.emacs.d/elpaca/repos by --manager)")
+	profileName     = flag.String("profile", "", "update repos of chemacs2 profile with given name")
+	allProfiles     = flag.Bool("all-profiles", false, "update repos of every chemacs2 profile")
+	managerFlag     = flag.String("manager", "auto", "package manager owning the repos: straight, elpaca, doom or auto")
+	rebuild         = flag.Bool("rebuild", false, "rebuild packages of updated repos by straight.el in running Emacs instead of restarting it")
+	ignoreLockfile  = flag.Bool("ignore-lockfile", false, "update also repos pinned by straight.el version lockfile")
+	doomSync        = flag.Bool("doom-sync", false, "run doom sync before restart of Doom Emacs with updated repos")
+	minAge          = flag.Duration("min-age", 0, "skip repos updated less than this long ago, e.g. 6h, without fetching them (default check every repo)")
+	watch           = flag.Duration("watch", 0, "keep running and update repos every interval, e.g. 6h; SIGHUP starts the next update at once")
+	watchIdle       = flag.Duration("watch-idle", 0, "with --watch restart Emacs only if it has been idle this long, e.g. 10m, deferring the restart to a later update otherwise")
+	lockWait        = flag.Duration("lock-wait", 0, "wait up to this long for another run to finish, e.g. 2m (default exit at once)")
+	timeout         = flag.Duration("timeout", 0, "network timeout of every repo update, e.g. 60s (default no timeout)")
+	depth           = flag.Int("depth", 0, "fetch only N recent commits of remote branches (default full history)")
+	retries         = flag.Int("retries", 2, "retry transient network failures of repo N times with exponential backoff")
+	jobs            = flag.Int("jobs", 8, "number of repos fetched concurrently, fetched changes are merged one repo at a time")
+	verbose         = flag.Bool("verbose", false, "log every git operation of every repo to stderr")
+	colorMode       = flag.String("color", "auto", "colorize output: auto, always or never, auto honors NO_COLOR")
+	themeName       = flag.String("theme", "dark", "color theme of output: dark, light or mono; colors of config [theme] table override it")
+	showCmdOutput   = flag.Bool("show-command-output", false, "stream output of restart and rebuild commands, by default it's shown only if they fail")
+	noHyperlinks    = flag.Bool("no-hyperlinks", false, "do not link commit hashes and paths by OSC 8 sequences, for terminals which mangle them")
+	showVersion     = flag.Bool("version", false, "print version and build info, then exit")
+	jsonOutput      = flag.Bool("json", false, "print report of the run as JSON document, without colors, same as --format json")
+	format          = flag.String("format", "text", "format of the run report: text, json, sexp (elisp for read in Emacs), org or porcelain, the latter ones without colors")
+	porcelain       = flag.Bool("porcelain", false, "print a tab-separated line per repo for scripts: status, name, old and new hash, commits, remote URL, error; same as --format porcelain")
+	quiet           = flag.Bool("quiet", false, "print only one line per updated repo instead of its commits")
+	noRestart       = flag.Bool("no-restart", envBool("UPDSTRAIGHT_NO_RESTART"), "do not restart Emacs daemon after update (env UPDSTRAIGHT_NO_RESTART)")
+	startDaemon     = flag.Bool("start-daemon", false, "start Emacs daemon after update if it's not running")
+	interactive     = flag.Bool("interactive", false, "show pending commits of every repo and ask before merging them")
+	check           = flag.Bool("check", false, "only check for pending updates: print \"N repos behind\" and exit 10 if any, 0 if up to date, 1 on errors")
+	templatePath    = flag.String("template", "", "commit template file (default ~/.config/updstraight/commit.tmpl if exists)")
+	showUnchanged   = flag.Bool("show-unchanged", false, "list up-to-date repos in the summary table instead of their count")
+	failFast        = flag.Bool("fail-fast", false, "stop updating further repos on the first failed one, repos in progress are finished")
+	autostash       = flag.Bool("autostash", false, "snapshot local changes of tracked files before pull and reapply them afterwards")
+	notify          = flag.Bool("notify", false, "send desktop notification with summary of the run")
+	socketName      = flag.String("socket-name", "", "socket name of Emacs daemon to restart: emacsclient -s NAME, emacs --daemon=NAME")
+	serverFile      = flag.String("server-file", "", "server file of Emacs daemon to restart: emacsclient -f FILE")
+	completionOrder = flag.Bool("completion-order", false, "print repos as soon as they are done instead of in discovery order")
+	timings         = flag.Bool("timings", false, "break durations of the slowest repos down into open, fetch, merge and log phases")
+	maxLog          = flag.Int("max-log", 0, "render at most N commits of every repo, 0 means unlimited")
+	updateDetached  = flag.Bool("update-detached", false, "fetch repos in detached HEAD and report how far behind the pinned commit is, without changing it")
+	proxyURL        = flag.String("proxy", "", "proxy of remotes, e.g. http://proxy:3128, socks5:// for ssh remotes (default HTTP_PROXY, HTTPS_PROXY, NO_PROXY)")
+	merge           = flag.Bool("merge", false, "merge upstream into diverged local branches with git merge instead of skipping them")
+	rebase          = flag.Bool("rebase", false, "rebase local commits of diverged branches onto upstream with git rebase instead of skipping them")
+	groupBy         = flag.String("group-by", "none", "group commit log of repo: none or author")
+	onlyBreaking    = flag.Bool("only-breaking", false, "show only commits which look like breaking changes and the repos having them")
+	feed            = flag.Bool("feed", false, "print new commits of all repos as one list sorted by date after one line per updated repo")
+	reportPath      = flag.String("report", "", "write Markdown report of the run to file, e.g. ~/emacs-updates.md, Org one to .org file")
+	reportAppend    = flag.Bool("report-append", false, "append report to the --report file instead of overwriting it")
+	webhook         = flag.String("webhook", "", "POST JSON summary of the run to URL")
+	webhookTemplate = flag.String("webhook-template", "", "template file of --webhook body, e.g. for Slack or Discord")
+	openMin         = flag.Int("open", 0, "open compare pages of repos updated by at least N commits in browser, 0 disables")
+	exitCode        = flag.Bool("exit-code", false, "exit with 4 instead of 0 when updates are applied")
+	switchDefault   = flag.Bool("switch-default", false, "check out the new default branch of remotes which have renamed it, e.g. main instead of master")
+	migrateTags     = flag.Bool("migrate-tags", false, "move legacy "+straightup.TagName+" tags to the update marker ref and delete them")
+	force           = flag.Bool("force", false, "fetch and hard-reset local branches and worktrees to upstream, discarding local commits and changes")
+	only            stringList
+	restartCmd      rawList
+	rebaseRepos     stringList
+	restartRepos    stringList
+	postUpdate      string           // hook of every updated repo
+	hooks           map[string]Hooks // of repos by name
+	forceRepos      stringList
+	branches        stringMap
+	exclude         stringList
+	tokenHosts      []string // https hosts UPDSTRAIGHT_TOKEN is sent to
+	breakingFlags   rawList
+	breaking        = straightup.DefaultBreakingPatterns
+	highlightFlags  rawList
+	grepFlags       rawList
+	highlight, grep straightup.Patterns
+	manager         = straightup.ManagerStraight
+	doomBin         = "doom"          // doom CLI of Doom Emacs config, found by ResolveManager
+	pins            map[string]string // commits of repos pinned by version lockfile
+	stat            statMode
+	writeLockfile   lockfileFlag
 )
 
-func ListEmacsStraightRepos() (repos []string, err error) {
+func init() {
+	flag.IntVar(jobs, "j", 8, "shorthand for --jobs")
+	flag.BoolVar(quiet, "q", false, "shorthand for --quiet")
+	flag.Var(&only, "only", "update only repos with given names (repeatable, comma separated)")
+	flag.Var(&restartCmd, "restart-cmd", "command of Emacs restart sequence (repeatable, executed in order)")
+	flag.Var(&branches, "branch", "pin branch of repo: repo=branch (repeatable, comma separated)")
+	flag.Var(&stat, "stat", "show files changed by update: --stat for the most changed ones, --stat=full for all")
+	flag.Var(&forceRepos, "force-repo", "hard-reset given repos to upstream like --force (repeatable, comma separated)")
+	flag.Var(&breakingFlags, "breaking-pattern", "regexp of commit messages flagged as breaking changes, replaces the defaults (repeatable)")
+	flag.Var(&highlightFlags, "highlight", "regexp of commit messages to highlight, case-insensitive unless (?-i) (repeatable)")
+	flag.Var(&grepFlags, "grep", "show only commits with messages matching regexp, case-insensitive unless (?-i) (repeatable)")
+	flag.Var(&writeLockfile, "write-lockfile", "write straight.el version lockfile with commits of processed repos: versions/default.el of repos directory, or --write-lockfile=path")
+	flag.Var(&exclude, "exclude", "never update repos with given names or globs (repeatable, comma separated)")
+}
+
+// Directory of updstraight configuration files
+func ConfigDir() (string, error) {
+	if d := os.Getenv("XDG_CONFIG_HOME"); d != "" {
+		return filepath.Join(d, "updstraight"), nil
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return filepath.Join(home, ".config", "updstraight"), nil
+}
+
+// Set up colors of output: auto detects TTY and honors NO_COLOR,
+// always forces at least 256 colors, never disables colors; legacy Windows
+// consoles get ANSI sequences enabled, auto disables colors if it fails
+func SetColorMode(mode string) error {
+	switch mode {
+	case "auto":
+		output = termenv.NewOutput(os.Stdout)
+	case "always":
+		output = termenv.NewOutput(os.Stdout, termenv.WithTTY(true))
+		output.Profile = min(output.Profile, termenv.ANSI256)
+	case "never":
+		output = termenv.NewOutput(os.Stdout, termenv.WithProfile(termenv.Ascii))
+	default:
+		return fmt.Errorf("unknown color mode %q, use auto, always or never", mode)
+	}
+	// no-op outside of Windows console, the mode is left enabled on exit
+	if _, err := termenv.EnableVirtualTerminalProcessing(output); err != nil && mode == "auto" {
+		output.Profile = termenv.Ascii
+	}
+	return nil
+}
+
+// Palette of output: colors of theme preset name overridden by the colors of
+// config
+func SetPalette(name string, colors straightup.Palette) error {
+	p, ok := straightup.Palettes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q, use dark, light or mono", name)
+	}
+	palette = p.With(colors)
+	return palette.Check()
+}
+
+// Report whether environment variable k is set to a true value
+func envBool(k string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(k))
+	return v
+}
+
+// Log step of repo p update and time spent on it to stderr if verbose mode is on
+func debug(p string, start time.Time, s ...any) {
+	if *verbose {
+		fmt.Fprintf(os.Stderr, "%s: %s (%s)\n", filepath.Base(p), straightup.Scrub(fmt.Sprint(s...)), time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// Values of repeatable key=value flag, every value may hold a comma separated list
+type stringMap map[string]string
+
+func (m *stringMap) String() string {
+	var l []string
+	for k, v := range *m {
+		l = append(l, k+"="+v)
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func (m *stringMap) Set(v string) error {
+	if *m == nil {
+		*m = make(stringMap)
+	}
+	for _, s := range strings.Split(v, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(s), "=")
+		if !ok || k == "" || v == "" {
+			return fmt.Errorf("expected key=value, got %q", s)
+		}
+		(*m)[k] = v
+	}
+	return nil
+}
+
+// Values of repeatable flag, kept as is
+type rawList []string
+
+func (l *rawList) String() string {
+	return strings.Join(*l, "; ")
+}
+
+func (l *rawList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// Values of repeatable flag, every value may hold a comma separated list
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// Mode of --stat flag: empty - off, true - compact, full - list every file
+type statMode string
+
+func (m *statMode) String() string {
+	return string(*m)
+}
+
+func (m *statMode) Set(v string) error {
+	switch v {
+	case "true", "full":
+		*m = statMode(v)
+	case "false":
+		*m = ""
+	default:
+		return fmt.Errorf("expected full, got %q", v)
+	}
+	return nil
+}
+
+// Allow plain --stat without value
+func (m *statMode) IsBoolFlag() bool {
+	return true
+}
+
+// Print warning to stderr
+func warn(s ...string) {
+	if pr := activePrinter.Load(); pr != nil {
+		// keep the progress line below the warning
+		pr.mu.Lock()
+		defer pr.mu.Unlock()
+		if pr.progress != nil {
+			pr.progress.Clear()
+			defer pr.progress.Draw()
+		}
+	}
+	fmt.Fprintln(os.Stderr, output.String(s...).Foreground(output.Color(palette.Notice)))
+}
+
+// Resolve --manager and default --dir by it and Emacs config directories
+// dirs, nil means the ones Emacs picks from; a given --dir of auto manager is
+// taken as the elpaca one if it is under elpaca directory, as the Doom one if
+// it is under .local/straight/repos
+func ResolveManager(dirs []string) (string, error) {
+	var err error
+	if dirs == nil {
+		if dirs, err = straightup.EmacsDirs(); err != nil {
+			return "", err
+		}
+	}
+	name, dir, other, err := straightup.ManagerReposDirIn(*managerFlag, dirs)
+	if err != nil {
+		return "", err
+	}
+	if *reposDir == "" {
+		*reposDir = dir
+		debug(filepath.Dir(filepath.Dir(dir)), time.Now(), "using ", name, " repos directory ", dir)
+		for _, p := range other {
+			warn("found also " + p + ", only " + dir + " is updated, use --dir to update the other one")
+		}
+	} else if *managerFlag == straightup.ManagerAuto {
+		name = straightup.ManagerStraight
+		switch p := filepath.Clean(*reposDir); {
+		case filepath.Base(filepath.Dir(p)) == straightup.ManagerElpaca:
+			name = straightup.ManagerElpaca
+		case strings.Contains(p, string(filepath.Separator)+filepath.Join(".local", "straight", "repos")):
+			name = straightup.ManagerDoom
+		}
+	}
+	if name == straightup.ManagerDoom {
+		// the CLI is in the config directory, repos may be under $DOOMLOCALDIR
+		for _, d := range append(dirs, parentDirs(*reposDir)...) {
+			if p := straightup.DoomBin(d); p != "" {
+				doomBin = p
+				break
+			}
+		}
+	}
+	return name, nil
+}
+
+// Parent directories of p, the nearest first
+func parentDirs(p string) (l []string) {
+	for d := filepath.Dir(filepath.Clean(p)); d != filepath.Dir(d); d = filepath.Dir(d) {
+		l = append(l, d)
 	}
-	repos, err = filepath.Glob(filepath.Join(home, ".emacs.d/straight/repos", "*"))
 	return
 }
 
-// Create a new tag with name Updated.At or change its reference to ref
-func CreateOrModifyGitTag(r *git.Repository, t string, ref *plumbing.Reference) (*plumbing.Reference, error) {
-	tag, err := r.Tag(t)
-	switch err {
-	case nil: // CASE 1:  tag exists, set new reference of tag
-		tag = plumbing.NewHashReference(tag.Name(), ref.Hash())
-		if err = r.Storer.SetReference(tag); err != nil {
-			return nil, err
+// Read straight.el version lockfile of --dir unless --ignore-lockfile is given;
+// with --write-lockfile it's the output of the run, pins are not honored then
+func LoadLockfile() (map[string]string, error) {
+	if *ignoreLockfile || writeLockfile != "" || manager == straightup.ManagerElpaca {
+		return nil, nil
+	}
+	p := straightup.LockfilePath(*reposDir)
+	l, err := straightup.ReadLockfile(p)
+	if len(l) > 0 {
+		debug(p, time.Now(), len(l), " repos are pinned")
+	}
+	return l, err
+}
+
+// Write version lockfile of the run if --write-lockfile is given, it is not
+// written after interruption as the commits of unfinished repos are unknown
+func SaveLockfile(reports []straightup.Result) {
+	if writeLockfile == "" {
+		return
+	}
+	if interrupted.Load() {
+		warn("lockfile is not written due to interruption")
+		return
+	}
+	p, err := writeLockfile.Path()
+	if err == nil {
+		err = WriteLockfile(p, reports)
+	}
+	if err != nil {
+		warn("cannot write lockfile:", err.Error())
+	} else if !*jsonOutput {
+		fmt.Println(output.String("versions lockfile written to", p).Faint())
+	}
+}
+
+// Chemacs2 profiles selected by --profile or --all-profiles
+func SelectProfiles() ([]straightup.Profile, error) {
+	switch {
+	case *allProfiles && *profileName != "":
+		return nil, errors.New("--profile and --all-profiles cannot be used together")
+	case *reposDir != "":
+		return nil, errors.New("--dir cannot be used with --profile or --all-profiles")
+	}
+	l, p, err := straightup.ReadChemacsProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if *allProfiles {
+		return l, nil
+	}
+	prof, ok := straightup.FindProfile(l, *profileName)
+	if !ok {
+		names := make([]string, len(l))
+		for i, v := range l {
+			names[i] = v.Name
 		}
-	case git.ErrTagNotFound: // CASE 2: tag does not exist, create a tag
-		if tag, err = r.CreateTag(TagName, ref.Hash(), nil); err != nil {
+		return nil, fmt.Errorf("%s: no profile %q, known profiles: %s", p, *profileName, strings.Join(names, ", "))
+	}
+	return []straightup.Profile{prof}, nil
+}
+
+// List repos of dir, if dir is empty the default straight repos directory is used
+func ListEmacsStraightRepos(dir string) ([]string, error) {
+	repos, other, err := straightup.DiscoverRepos(dir)
+	for _, p := range other {
+		debug(p, time.Now(), "skipped, not a git repository")
+	}
+	return repos, err
+}
+
+// Network settings of repo updates given by flags
+func network() straightup.Network {
+	nw := straightup.Network{
+		Depth:      *depth,
+		Retries:    *retries,
+		Proxy:      *proxyURL,
+		Passphrase: askPassphrase,
+		TokenHosts: tokenHosts,
+	}
+	if *verbose {
+		nw.Log = os.Stderr
+	}
+	return nw
+}
+
+// Look of rendered results given by flags
+func theme() straightup.Theme {
+	return straightup.Theme{
+		Output:        output,
+		Commit:        commitTpl,
+		MaxLog:        *maxLog,
+		Brief:         *quiet || *feed,
+		FullStat:      stat == "full",
+		Breaking:      breaking,
+		OnlyBreaking:  *onlyBreaking,
+		Highlight:     highlight,
+		Grep:          grep,
+		GroupByAuthor: *groupBy == "author",
+		Hyperlinks:    output.Profile != termenv.Ascii && !*noHyperlinks,
+		Palette:       palette,
+	}
+}
+
+// Settings of repo update given by flags and config
+func repoOptions(name string) straightup.Options {
+	o := straightup.Options{
+		Network:        network(),
+		DryRun:         *dryRun,
+		Branch:         branches[name],
+		Autostash:      *autostash,
+		Merge:          *merge,
+		Rebase:         RebaseRepo(name),
+		Force:          ForceRepo(name),
+		UpdateDetached: *updateDetached,
+		MigrateTags:    *migrateTags,
+		SwitchDefault:  *switchDefault,
+		MinAge:         *minAge,
+		Stat:           stat != "",
+		Breaking:       breaking,
+		Timeout:        *timeout,
+		Warnings:       warnWriter{},
+	}
+	if pin, ok := pins[name]; ok {
+		o.Pinned, o.PinnedBy = pin, straightup.LockfileName
+	}
+	if *interactive {
+		o.Confirm = ConfirmUpdate
+	}
+	return o
+}
+
+// Writer of warnings, every line is printed by warn
+type warnWriter struct{}
+
+func (warnWriter) Write(p []byte) (int, error) {
+	warn(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Report whether repo is hard-reset to upstream, by --force or --force-repo
+func ForceRepo(name string) bool {
+	return *force || slices.Contains(forceRepos, name)
+}
+
+// Report whether diverged branch of repo is rebased, by --rebase or config
+func RebaseRepo(name string) bool {
+	return *rebase || slices.Contains(rebaseRepos, name)
+}
+
+// Template of commit rendering, loaded by LoadCommitTemplate
+var commitTpl *template.Template
+
+// Colors of output by --theme and config, set by SetPalette
+var (
+	palette     = straightup.DefaultPalette
+	themeColors straightup.Palette // of config
+)
+
+// Load commit template from file p, if p is empty try commit.tmpl of config
+// directory and fall back to the built-in template if there is no such file
+func LoadCommitTemplate(p string) (*template.Template, error) {
+	explicit := p != ""
+	if !explicit {
+		cfgDir, err := ConfigDir()
+		if err != nil {
 			return nil, err
 		}
+		p = filepath.Join(cfgDir, "commit.tmpl")
+	}
+	p, err := straightup.ExpandHome(p)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return straightup.NewCommitTemplate(output, "tpl", straightup.DefaultCommitTemplate)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// name the template by its file, so parse errors point to file:line
+	return straightup.NewCommitTemplate(output, p, string(b))
+}
+
+// Report of the whole run, used by JSON output
+type RunReport struct {
+	RestartNeeded bool                `json:"restart_needed"`
+	RestartRepos  []string            `json:"restart_repos,omitempty"` // repos which need the restart
+	Interrupted   bool                `json:"interrupted,omitempty"`   // by SIGINT or SIGTERM, the report is partial
+	Excluded      int                 `json:"excluded"`
+	Duration      time.Duration       `json:"duration_ns"`
+	Downloaded    int64               `json:"downloaded_bytes"` // by all repos
+	Repos         []straightup.Result `json:"repos"`
+}
+
+// Error of repo update
+type RepoError struct {
+	Path string
+	Err  error
+}
+
+func (e RepoError) Error() string {
+	return e.Path + ": " + straightup.Scrub(e.Err.Error())
+}
+
+// Print failed repos section
+func PrintFailures(failures []RepoError) {
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Println(output.String("Failed repos:").Foreground(output.Color(palette.Error)).Bold())
+	for _, v := range failures {
+		fmt.Println(output.String("\t" + v.Error()).Foreground(output.Color(palette.Error)))
 	}
-	return tag, nil
 }
 
-// Pull git changes and return true if the local workdir has updated
-func PullGitChanges(r *git.Repository) (bool, error) {
-	w, err := r.Worktree()
+var (
+	promptMu     sync.Mutex
+	promptAnswer string // sticky answer given for all remaining repos: a or q
+	stdin        = bufio.NewReader(os.Stdin)
+)
+
+// Fetch and show pending commits of repo, then ask whether they should be
+// merged: y - yes, n - no, a - yes to all remaining repos, q - no to all
+// remaining repos. Prompts of concurrent workers are serialized.
+func ConfirmUpdate(ctx context.Context, p string, r *git.Repository, rr *git.Remote, head *plumbing.Reference) (bool, error) {
+	if err := straightup.FetchGitChanges(ctx, rr, network()); err != nil {
+		return false, err
+	}
+	tip, err := straightup.RemoteTrackingRef(r, rr.Config().Name, head.Name())
 	if err != nil {
 		return false, err
 	}
-	err = w.Pull(&git.PullOptions{})
-	switch err {
-	case git.NoErrAlreadyUpToDate:
-		return false, nil
-	default:
+	var n int
+	l, err := straightup.GetGitLog(r, head, tip, &n, theme())
+	if err != nil || n == 0 {
+		return n == 0, err
+	}
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	switch promptAnswer {
+	case "a":
 		return true, nil
+	case "q":
+		return false, nil
 	}
 
+	fmt.Println(
+		output.String("Pending from", straightup.Scrub(rr.Config().URLs[0])).Foreground(output.Color(palette.Header)),
+		output.String(strconv.Itoa(n), "new commits").Foreground(output.Color(palette.Count)),
+	)
+	fmt.Println(output.String("local path:", p).Faint())
+	fmt.Print(l)
+	for {
+		fmt.Print(output.String("Merge", filepath.Base(p), "changes? [y/n/a/q] ").Bold())
+		answer, err := stdin.ReadString('\n')
+		if err != nil { // stdin is closed, treat as quit
+			fmt.Println()
+			promptAnswer = "q"
+			return false, nil
+		}
+		switch answer = strings.ToLower(strings.TrimSpace(answer)); answer {
+		case "y":
+			return true, nil
+		case "n":
+			return false, nil
+		case "a", "q":
+			promptAnswer = answer
+			return answer == "a", nil
+		}
+	}
 }
 
-var commitBrief = `{{"\t"}}{{ .Committer.When.Format "2006-01-02" | Color "140" }} {{ slice .Hash.String 0 6 | Color "104"}} {{ Color "111" .Author.String }}
-{{"\t"}}{{"\t"}}{{ replaceAll .Message "\n" "\n\t\t" | Color "108"}}
-`
+// Read passphrase of key p from terminal without echo
+func askPassphrase(p string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%s is encrypted, add it to ssh-agent", p)
+	}
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	if pr := activePrinter.Load(); pr != nil {
+		pr.mu.Lock()
+		defer pr.mu.Unlock()
+		if pr.progress != nil {
+			pr.progress.Clear()
+			defer pr.progress.Draw()
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase of %s: ", p)
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return pass, err
+}
 
-// Print git log to buffer, inspect commits since given time,
-// count the number of commits and save to n
-func GetGitLog(r *git.Repository, ref *plumbing.Reference, n *int) (string, error) {
-	// KLUDGE use LogOptions.From doesn't work, use alternative method LogOptions.Since instead
-	// cIter, err := r.Log(&git.LogOptions{From: tag.Hash(), Order: git.LogOrderDFSPost})
-	var buf bytes.Buffer
+// Context of network operations of subcommands, limited by --timeout
+func NetworkContext() (context.Context, context.CancelFunc) {
+	if *timeout > 0 {
+		return context.WithTimeout(context.Background(), *timeout)
+	}
+	return context.WithCancel(context.Background())
+}
 
-	c, err := r.CommitObject(ref.Hash())
+// Writer coloring every line of subprocess output by its own sequences, so
+// chunks split at arbitrary bytes and interleaved stdout and stderr do not
+// stack colors mid-line; input is buffered up to the last newline, Close
+// flushes the rest
+type ColoredWriter struct {
+	c      termenv.Color
+	w      io.Writer
+	prefix string      // put before every line, e.g. the name of command
+	mu     *sync.Mutex // shared by the writers of w, whole lines are written under it
+	buf    []byte      // incomplete line
+}
+
+// Writer of lines to w colored by c and prefixed by prefix, mu serializes
+// writers sharing w
+func NewColoredWriter(w io.Writer, c termenv.Color, prefix string, mu *sync.Mutex) *ColoredWriter {
+	return &ColoredWriter{c: c, w: w, prefix: prefix, mu: mu}
+}
+
+func (c *ColoredWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	i := bytes.LastIndexByte(c.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	err := c.writeLines(c.buf[:i])
+	c.buf = c.buf[:copy(c.buf, c.buf[i+1:])]
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Write the incomplete line left, newline is added
+func (c *ColoredWriter) Close() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.writeLines(c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+// Write lines of b, which has no trailing newline, by a single write
+func (c *ColoredWriter) writeLines(b []byte) error {
+	var out strings.Builder
+	for _, l := range strings.Split(string(b), "\n") {
+		s := c.prefix + strings.TrimSuffix(l, "\r")
+		if output.Profile != termenv.Ascii {
+			s = output.String(s).Foreground(c.c).String()
+		}
+		out.WriteString(s + "\n")
+	}
+	if c.mu != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
 	}
+	_, err := io.WriteString(c.w, out.String())
+	return err
+}
+
+// Runner of external commands, faked by tests to record the invocations
+type Runner interface {
+	Run(name string, args ...string) error
+}
+
+// Runner executing commands with colored output
+type execRunner struct {
+	dir     string    // working directory, the current one if empty
+	env     []string  // added to the environment
+	w       io.Writer // of output, stdout (stderr for JSON output) if nil
+	named   bool      // prefix lines of output with the name of command
+	capture bool      // show output only if the command fails, unless --show-command-output
+}
 
-	// KLUDGE hide the Updated.At tagged commit, show only after it
-	t := c.Committer.When.Add(time.Second)
-	cIter, err := r.Log(&git.LogOptions{Since: &t})
+// Limit of output of command kept by capture, the oldest lines are dropped
+const capturedOutput = 64 << 10
+
+// Buffer keeping at most limit bytes of the latest lines written to it
+type cappedBuffer struct {
+	buf     []byte
+	limit   int
+	dropped int // bytes of the oldest lines dropped
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if cut := len(b.buf) - b.limit; cut > 0 {
+		if i := bytes.IndexByte(b.buf[cut:], '\n'); i >= 0 {
+			cut += i + 1 // keep whole lines
+		}
+		b.dropped += cut
+		b.buf = b.buf[:copy(b.buf, b.buf[cut:])]
+	}
+	return len(p), nil
+}
+
+func (e execRunner) Run(name string, args ...string) (err error) {
+	// keep stdout clean for JSON document
+	var w io.Writer = os.Stdout
+	if *jsonOutput {
+		w = os.Stderr
+	}
+	if e.w != nil {
+		w = e.w
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = e.dir
+	if len(e.env) > 0 {
+		cmd.Env = append(os.Environ(), e.env...)
+	}
+	var prefix string
+	if e.named {
+		prefix = filepath.Base(name) + ": "
+	}
+	capture := e.capture && !*showCmdOutput
+	out, captured := w, &cappedBuffer{limit: capturedOutput}
+	if capture {
+		out = captured
+	}
+	mu := new(sync.Mutex)
+	stdout := NewColoredWriter(out, output.Color(palette.Stdout), prefix, mu)
+	stderr := NewColoredWriter(out, output.Color(palette.Stderr), prefix, mu)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err = cmd.Run()
+	for _, cw := range []*ColoredWriter{stdout, stderr} {
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if !capture {
+		return
+	}
+	line := strings.Join(cmd.Args, " ")
+	if err == nil {
+		fmt.Fprintln(w, output.String("✓", line).Faint())
+		return
+	}
+	status := err.Error()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status = "exit code " + strconv.Itoa(exitErr.ExitCode())
+	}
+	fmt.Fprintln(w, output.String("✗", line, "("+status+")").Foreground(output.Color(palette.Error)))
+	if captured.dropped > 0 {
+		fmt.Fprintln(w, output.String("… first", FormatBytes(int64(captured.dropped)), "of output truncated").Faint())
+	}
+	w.Write(captured.buf)
+	return
+}
+
+// Runner of probes like daemonRunning: failure of the command is an answer,
+// not an error, so output of execRunner is discarded
+func probeRunner(run Runner) Runner {
+	if e, ok := run.(execRunner); ok {
+		e.w, e.named, e.capture = io.Discard, false, false
+		return e
+	}
+	return run
+}
+
+// Command of emacsclient addressing the daemon by --socket-name or
+// --server-file, file of the latter is returned too
+func emacsclient() (client []string, server string, err error) {
+	client = []string{clientProgram(targetOS)}
+	if *socketName != "" {
+		client = append(client, "-s", *socketName)
+	}
+	if *serverFile != "" {
+		if server, err = straightup.ExpandHome(*serverFile); err != nil {
+			return nil, "", err
+		}
+		client = append(client, "-f", server)
+	}
+	return client, server, nil
+}
+
+// Default commands of Emacs restart, the daemon is addressed by --socket-name
+// or --server-file, whose base name is the server name of the relaunched daemon
+func restartCommands() ([][]string, error) {
+	client, server, err := emacsclient()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	daemon := "--daemon"
+	if *socketName != "" {
+		daemon += "=" + *socketName
+	} else if server != "" {
+		daemon += "=" + filepath.Base(server)
 	}
+	return [][]string{append(client, "-e", "(kill-emacs)"), append(emacsProgram(targetOS), daemon)}, nil
+}
 
-	defer cIter.Close()
+// OS the default restart commands are chosen for, set by tests
+var targetOS = runtime.GOOS
 
-	tpl := template.New("tpl").
-		Funcs(output.TemplateFuncs()).
-		Funcs(template.FuncMap{"replaceAll": strings.ReplaceAll})
-	tpl, err = tpl.Parse(commitBrief)
+// Program of emacsclient on goos, emacsclientw of Windows opens no console
+// window
+func clientProgram(goos string) string {
+	if goos == "windows" {
+		return "emacsclientw"
+	}
+	return "emacsclient"
+}
+
+// Command launching Emacs daemon on goos, there is no terminal frame to keep
+// out with -nw on Windows, runemacs starts Emacs without console window
+func emacsProgram(goos string) []string {
+	if goos == "windows" {
+		return []string{"runemacs"}
+	}
+	return []string{"emacs", "-nw"}
+}
+
+// Report whether Emacs daemon addressed by emacsclient command is running:
+// it's asked to evaluate t, waiting for the answer at most 5 seconds
+func daemonRunning(run Runner, client []string) bool {
+	return probeRunner(run).Run(client[0], append(client[1:], "-w", "5", "-u", "-e", "t")...) == nil
+}
+
+// Rebuild packages of changed repos by straight.el in running Emacs, the
+// packages of repos are found by builds of straight; the elisp form is
+// returned to be evaluated by hand if the rebuild fails
+func rebuildPackages(run Runner, changed []string) (string, error) {
+	builds, err := straightup.BuildPackages(*reposDir)
 	if err != nil {
-		return "", err
+		debug(*reposDir, time.Now(), "packages of repos are unknown: ", err)
+	}
+	form := straightup.RebuildForm(straightup.RepoPackages(changed, builds))
+	client, _, err := emacsclient()
+	if err != nil {
+		return form, err
+	}
+	if err = run.Run(client[0], append(client[1:], "-e", form)...); err != nil {
+		return form, fmt.Errorf("rebuild command failed: %w", err)
 	}
+	return form, nil
+}
 
-	// process every single commit
-	f := func(n *int) func(c *object.Commit) error {
-		return func(c *object.Commit) error {
-			*n++
-			if err := tpl.Execute(&buf, c); err != nil {
-				return err
+// Split command line to arguments by spaces, single or double quoted
+// arguments may contain spaces
+func SplitCommand(s string) (args []string, err error) {
+	var (
+		arg    strings.Builder
+		quote  rune
+		inWord bool
+	)
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				args = append(args, arg.String())
+				arg.Reset()
+				inWord = false
 			}
-			return nil
+		default:
+			arg.WriteRune(c)
+			inWord = true
 		}
-	}(n)
-	err = cIter.ForEach(f)
-	return buf.String(), err
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command: %s", s)
+	}
+	if inWord {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return
 }
 
-var restartEmacsIsNeeded bool
+// Run Emacs restart sequence by run: --restart-cmd commands or the default
+// ones, preceded by doom sync with --doom-sync; the custom sequence stops on
+// the first failed command; the default one checks the daemon first: if it's
+// not running there is nothing to kill, it's started only with
+// --start-daemon, and failed kill does not prevent the start
+func restartEmacs(run Runner) error {
+	commands, err := restartCommands()
+	if err != nil {
+		return err
+	}
+	if len(restartCmd) > 0 {
+		commands = commands[:0]
+		for _, v := range restartCmd {
+			args, err := SplitCommand(v)
+			if err != nil {
+				return fmt.Errorf("restart command %q failed: %w", v, err)
+			}
+			commands = append(commands, args)
+		}
+	}
+	if manager == straightup.ManagerDoom && *doomSync {
+		if err := run.Run(doomBin, "sync"); err != nil {
+			return fmt.Errorf("restart command %q failed: %w", doomBin+" sync", err)
+		}
+	}
+	if len(restartCmd) > 0 {
+		for _, args := range commands {
+			if err := run.Run(args[0], args[1:]...); err != nil {
+				return fmt.Errorf("restart command %q failed: %w", strings.Join(args, " "), err)
+			}
+		}
+		emacsRestarted = true
+		return nil
+	}
 
-func UpdateEmacsStraightRepo(p string, wg *sync.WaitGroup) {
-	var (
-		r         *git.Repository
-		tag, head *plumbing.Reference
-		rr        *git.Remote
-		err       error
-	)
+	client, _, err := emacsclient()
+	if err != nil {
+		return err
+	}
+	kill, start := commands[0], commands[1]
+	if daemonRunning(run, client) {
+		if err := run.Run(kill[0], kill[1:]...); err != nil {
+			warn(fmt.Sprintf("restart command %q failed: %v, starting Emacs anyway", strings.Join(kill, " "), err))
+		}
+	} else if !*startDaemon {
+		if !*jsonOutput {
+			fmt.Println(output.String("Emacs daemon is not running, no restart needed (--start-daemon starts it)").Faint())
+		}
+		return nil
+	}
+	if err := run.Run(start[0], start[1:]...); err != nil {
+		return fmt.Errorf("restart command %q failed: %w", strings.Join(start, " "), err)
+	}
+	emacsRestarted = true
+	return nil
+}
 
-	defer wg.Done()
+// Result of repo update sent by worker
+type repoResult struct {
+	i   int // index of repo
+	rep straightup.Result
+	out *bytes.Buffer // rendered output of repo
+	err error
+}
 
-	if r, err = git.PlainOpen(p); err != nil {
-		log.Fatal(err)
+// Call f for every repo in the pool of --jobs workers, wait until all done
+func ForEachRepo(repos []string, f func(i int, p string)) {
+	// feed indexes of repos to the pool of workers
+	queue := make(chan int)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < max(*jobs, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				f(i, repos[i])
+			}
+		}()
 	}
-	if head, err = r.Head(); err != nil {
-		log.Fatal(err)
+	for i := range repos {
+		queue <- i
 	}
-	if rr, err = r.Remote("origin"); err != nil {
-		log.Fatal(err)
+	close(queue)
+	wg.Wait()
+}
+
+// Printer of buffered repo outputs, every output is written at once, so
+// outputs of concurrent workers do not interleave
+type RepoPrinter struct {
+	mu      sync.Mutex
+	next    int // index of the next repo in discovery order
+	pending map[int]*bytes.Buffer
+
+	progress *Progress // live progress line under the outputs, nil if disabled
+}
+
+// Print output of i-th repo: in completion order if --completion-order is
+// set, in discovery order otherwise, i.e. keep it until the preceding repos
+// are printed
+func (pr *RepoPrinter) Print(i int, buf *bytes.Buffer) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.progress != nil {
+		pr.progress.done++
+		pr.progress.Clear()
+		defer pr.progress.Draw()
+	}
+	if *completionOrder {
+		buf.WriteTo(os.Stdout)
+		return
 	}
-	if tag, err = CreateOrModifyGitTag(r, TagName, head); err != nil {
-		log.Fatal(err)
+	if pr.pending == nil {
+		pr.pending = make(map[int]*bytes.Buffer)
 	}
-	if _, err = PullGitChanges(r); err != nil {
-		log.Fatal(err)
+	pr.pending[i] = buf
+	for ; pr.pending[pr.next] != nil; pr.next++ {
+		pr.pending[pr.next].WriteTo(os.Stdout)
+		delete(pr.pending, pr.next)
 	}
+}
 
-	var (
-		n int
-		l string
-	)
-	l, err = GetGitLog(r, tag, &n)
+// Show in progress line that update of repo p has started
+func (pr *RepoPrinter) Start(p string) {
+	if pr.progress == nil {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.progress.current = filepath.Base(p)
+	pr.progress.Clear()
+	pr.progress.Draw()
+}
 
-	if n > 0 {
-		restartEmacsIsNeeded = true
-		fmt.Println(
-			output.String("Fetched from", rr.Config().URLs[0]).Foreground(termenv.ANSIYellow),
-			output.String(strconv.Itoa(n), "new commits").Foreground(output.Color("208")),
-		)
-		fmt.Println(output.String("local path:", p).Faint())
-		fmt.Print(l)
+// Count repo p as fetched in progress line, all repos are counted as fetched
+// if p is empty
+func (pr *RepoPrinter) Fetched(p string) {
+	if pr.progress == nil {
+		return
 	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if p == "" {
+		pr.progress.fetched = pr.progress.total
+	} else {
+		pr.progress.fetched++
+	}
+	pr.progress.Clear()
+	pr.progress.Draw()
 }
 
-type ColoredWriter struct {
-	c termenv.Color
+// Remove progress line, it's not drawn anymore
+func (pr *RepoPrinter) Close() {
+	if pr.progress == nil {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.progress.Clear()
+	pr.progress = nil
+	activePrinter.Store(nil)
+}
+
+// Walk trought emacs straight repos directories, keep repos with given names
+// (all if none) and drop excluded ones, return also the number of excluded repos
+func SelectRepos(names []string) (repos []string, excluded int) {
+	repos, err := ListEmacsStraightRepos(*reposDir)
+	if err != nil {
+		fatal(err)
+	}
+	if len(names) > 0 {
+		var unknown []string
+		repos, unknown = straightup.FilterReposByName(repos, names)
+		for _, v := range unknown {
+			warn("warning: no such repo:", v)
+		}
+	}
+
+	cfgDir, err := ConfigDir()
+	if err != nil {
+		fatal(err)
+	}
+	patterns, err := straightup.ReadExcludeFile(filepath.Join(cfgDir, "exclude"))
+	if err != nil {
+		fatal(err)
+	}
+	if repos, excluded, err = straightup.ExcludeRepos(repos, append(patterns, exclude...)); err != nil {
+		fatal(err)
+	}
+	return
 }
 
-func (c ColoredWriter) Write(p []byte) (n int, err error) {
-	s := output.String(string(p)).Foreground(c.c)
-	_, err = os.Stdout.WriteString(s.String())
-	return len(p), err
+// Names of repos which have got new commits
+func UpdatedRepos(reports []straightup.Result) []string {
+	var l []string
+	for _, v := range reports {
+		if v.NewCommits > 0 && v.Status != straightup.StatusPending {
+			l = append(l, v.Name)
+		}
+	}
+	return l
 }
 
-func runCommand(s ...string) (err error) {
-	cmd := exec.Command(s[0], s[1:]...)
-	cmd.Stdout = ColoredWriter{c: output.Color("147")}
-	cmd.Stderr = ColoredWriter{c: output.Color("175")}
-	err = cmd.Run()
-	output.Reset()
+// Split changed repos into the ones Emacs has to be restarted for and the
+// others: restart_repos of config lists the former, if it's empty every
+// changed repo needs the restart
+func SplitRestartRepos(changed []string) (restart, other []string) {
+	if len(restartRepos) == 0 {
+		return changed, nil
+	}
+	for _, v := range changed {
+		if slices.Contains(restartRepos, v) {
+			restart = append(restart, v)
+		} else {
+			other = append(other, v)
+		}
+	}
 	return
 }
 
-func restartEmacs() {
-	commands := []string{"emacsclient -e (kill-emacs)", "emacs -nw --daemon"}
-	for _, v := range commands {
-		err := runCommand(strings.Split(v, " ")...)
-		if err != nil {
-			log.Fatal(err)
+// Names of updated repos which Emacs has to be restarted for to load them
+func RestartTriggers(reports []straightup.Result) []string {
+	restart, _ := SplitRestartRepos(UpdatedRepos(reports))
+	return restart
+}
+
+// Render result of repo update to out, nothing is rendered for JSON output
+// and --check
+func renderResult(out io.Writer, rep straightup.Result) error {
+	if *jsonOutput || *check {
+		return nil
+	}
+	return straightup.RenderResult(out, rep, theme())
+}
+
+// Print new commits of all repos merged into one list, newest first
+func PrintFeed(reports []straightup.Result) {
+	l := straightup.Feed(reports)
+	if len(l) == 0 {
+		return
+	}
+	fmt.Println(output.String("New commits of all repos:").Bold())
+	if err := straightup.RenderFeed(os.Stdout, l, theme()); err != nil {
+		warn("cannot render feed:", err.Error())
+	}
+}
+
+// Number of repos with new commits which are not merged by dry run
+func PendingRepos(reports []straightup.Result) (n int) {
+	for _, v := range reports {
+		if v.Status == straightup.StatusPending {
+			n++
+		}
+	}
+	return
+}
+
+// Set by the first SIGINT or SIGTERM of the run
+var interrupted atomic.Bool
+
+// Set when Emacs restart sequence has been completed
+var emacsRestarted bool
+
+// Context of the run, canceled on SIGINT or SIGTERM; the handler is removed
+// then, so the next signal terminates the process at once
+func InterruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		signal.Stop(sig)
+		interrupted.Store(true)
+		cancel()
+		warn("interrupted, waiting for repos in progress, press Ctrl-C again to exit immediately")
+	}()
+	return ctx
+}
+
+// Restart Emacs if some repos have changed, only for the ones of
+// restart_repos if it's set, unless it's suppressed by --no-restart or the
+// run is interrupted; Doom Emacs is restarted only with
+// --doom-sync, the command to run is printed otherwise; with --rebuild the
+// packages are rebuilt in running Emacs, it's restarted only if that fails
+func RestartEmacsIfNeeded(changed []string) error {
+	if len(changed) == 0 {
+		return nil
+	}
+	if manager == straightup.ManagerElpaca && !*jsonOutput {
+		fmt.Println(output.String("Run M-x elpaca-rebuild for changed packages to rebuild them:", strings.Join(changed, ", ")).Foreground(output.Color(palette.Warning)))
+	}
+	if manager == straightup.ManagerDoom && !*doomSync && !interrupted.Load() {
+		if !*jsonOutput {
+			fmt.Println(output.String("Doom Emacs: run", doomBin, "sync to rebuild changed packages, then restart Emacs").Foreground(output.Color(palette.Warning)).Bold(),
+				output.String("(changed: "+strings.Join(changed, ", ")+"; --doom-sync does it)").Faint())
+		}
+		return nil
+	}
+	restart, other := SplitRestartRepos(changed)
+	if *rebuild && manager == straightup.ManagerStraight && !interrupted.Load() {
+		form, err := rebuildPackages(execRunner{named: true, capture: true}, changed)
+		if err == nil {
+			return nil
+		}
+		warn("cannot rebuild packages in running Emacs:", err.Error())
+		if *noRestart || len(restart) == 0 {
+			if !*jsonOutput {
+				fmt.Println(output.String("Evaluate in Emacs to rebuild changed packages:").Foreground(output.Color(palette.Warning)).Bold(), form)
+			}
+			return nil
+		}
+		warn("restarting Emacs instead")
+	}
+	if len(restart) == 0 {
+		if !*jsonOutput && manager != straightup.ManagerElpaca {
+			fmt.Println(output.String("Emacs restart is not needed, none of restart_repos changed; rebuild changed packages with M-x straight-rebuild-package:").Foreground(output.Color(palette.Warning)),
+				strings.Join(other, ", "))
+		}
+		return nil
+	}
+	changed = restart
+	if *noRestart || interrupted.Load() {
+		reason := "--no-restart"
+		if interrupted.Load() {
+			reason = "interruption"
+		}
+		if !*jsonOutput {
+			fmt.Println(output.String("Emacs restart is recommended, skipped due to "+reason).Foreground(output.Color(palette.Warning)).Bold(),
+				output.String("(changed: "+strings.Join(changed, ", ")+")").Faint())
+		}
+		return nil
+	}
+	return restartEmacs(execRunner{named: true, capture: true})
+}
+
+// Exit code of the finished run by its failures, error of Emacs restart and
+// changed repos; the restart failure wins, so wrappers can retry just the restart
+func RunExitCode(failures []RepoError, restartErr error, changed []string) int {
+	switch {
+	case interrupted.Load():
+		return ExitInterrupted
+	case restartErr != nil:
+		return ExitRestart
+	case len(failures) > 0:
+		return ExitFailed
+	case *exitCode && len(changed) > 0:
+		return ExitUpdated
+	}
+	return 0
+}
+
+// Log fatal setup error and exit with ExitSetup
+func fatal(v ...any) {
+	log.Print(v...)
+	os.Exit(ExitSetup)
+}
+
+// Log formatted fatal setup error and exit with ExitSetup
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(ExitSetup)
+}
+
+// Print usage of flags followed by exit codes
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: %s [flags] [command]\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+	fmt.Fprintf(w, `
+Exit codes:
+  0    success, nothing to update or updates are applied
+  %d    some repos have failed to update
+  %d    fatal setup error: bad flags or config, missing repos directory
+  %d    Emacs restart has failed, the repos are updated, retry just the restart
+  %d    updates are applied, with --exit-code only
+  %d    another run is in progress, it holds the lock past --lock-wait
+  %d   some repos have pending updates, with --check
+  %d  interrupted by SIGINT or SIGTERM
+`, ExitFailed, ExitSetup, ExitRestart, ExitUpdated, ExitLocked, ExitBehind, ExitInterrupted)
+}
+
+// Update repos in two phases printing their results: remotes are fetched
+// concurrently first, then fetched changes are merged repo by repo in
+// discovery order, so interruption of the fetch leaves all worktrees as
+// they were; stop is set on the first failure with --fail-fast and makes the
+// rest of repos skipped
+func UpdateRepos(ctx context.Context, repos []string, stop *atomic.Bool) ([]straightup.Result, []RepoError) {
+	var (
+		reports  = make([]straightup.Result, len(repos))
+		failures []RepoError
+		results  = make(chan repoResult)
+		fetched  = make([]*straightup.Fetched, len(repos))
+	)
+	pr := NewRepoPrinter(len(repos))
+	go func() {
+		t := time.Now()
+		ForEachRepo(repos, func(i int, p string) {
+			if ctx.Err() == nil && !stop.Load() {
+				pr.Start(p)
+				fetched[i] = straightup.FetchRepo(ctx, p, repoOptions(filepath.Base(p)))
+			}
+			pr.Fetched(p)
+		})
+		pr.Fetched("")
+		debug("fetch", t, "fetched ", len(repos), " repos with ", max(*jobs, 1), " jobs")
+
+		t = time.Now()
+		for i, p := range repos {
+			res := repoResult{i: i, out: new(bytes.Buffer)}
+			switch {
+			case ctx.Err() != nil:
+				res.rep = straightup.Result{Name: filepath.Base(p), Path: p, Status: straightup.StatusInterrupted}
+			case stop.Load():
+				res.rep = straightup.Result{Name: filepath.Base(p), Path: p, Status: straightup.StatusSkipped}
+			default:
+				pr.Start(p)
+				o := repoOptions(filepath.Base(p))
+				o.Fetched = fetched[i]
+				var hookOut io.Writer // stderr for JSON output
+				if !*jsonOutput {
+					hookOut = res.out
+				}
+				if cmd := hooks[filepath.Base(p)].PreUpdate; cmd != "" {
+					o.BeforeMerge = preUpdateHook(cmd, hookOut)
+				}
+				res.rep, res.err = straightup.UpdateRepo(ctx, p, o)
+				if err := renderResult(res.out, res.rep); err != nil {
+					res.err = errors.Join(res.err, fmt.Errorf("render log: %w", err))
+				}
+				if err := postUpdateHooks(&res.rep, hookOut); err != nil {
+					res.err = errors.Join(res.err, err)
+				}
+			}
+			results <- res
+		}
+		debug("merge", t, "merged ", len(repos), " repos")
+		close(results)
+	}()
+	var (
+		done  = make([]bool, len(repos))
+		grace <-chan time.Time // repos in progress are given up after interruption
+	)
+	interrupt := ctx.Done()
+collect:
+	for {
+		select {
+		case v, ok := <-results:
+			if !ok {
+				break collect
+			}
+			pr.Print(v.i, v.out)
+			reports[v.i], done[v.i] = v.rep, true
+			if v.err != nil && v.rep.Status != straightup.StatusInterrupted {
+				failures = append(failures, RepoError{repos[v.i], v.err})
+				if *failFast {
+					stop.Store(true)
+				}
+			}
+		case <-interrupt:
+			interrupt, grace = nil, time.After(interruptGrace)
+		case <-grace:
+			warn("repos in progress have not finished in", interruptGrace.String()+", giving up")
+			for i, p := range repos {
+				if !done[i] {
+					reports[i] = straightup.Result{Name: filepath.Base(p), Path: p, Status: straightup.StatusInterrupted}
+					pr.Print(i, new(bytes.Buffer))
+				}
+			}
+			break collect
 		}
 	}
+	pr.Close()
+	return reports, failures
 }
 
 func main() {
-	// walk trought emacs straight repos directories
-	repos, err := ListEmacsStraightRepos()
-	if err != nil {
-		log.Fatal(err)
+	flag.Usage = usage
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(Version())
+		return
+	}
+	Version() // resolves version by build info if it's not set by ldflags
+	straightup.Version = version
+	if err := ApplyConfig(*configPath); err != nil {
+		fatal(err)
+	}
+	if *check {
+		*dryRun, *interactive = true, false
+	}
+	if *porcelain {
+		*format = "porcelain"
+	}
+	switch *format {
+	case "text":
+		if *jsonOutput {
+			*format = "json"
+		}
+	case "json", "sexp", "org", "porcelain":
+		// machine-readable output, stdout is kept clean for the report
+		*jsonOutput = true
+	default:
+		fatalf("unknown --format %q, use text, json, sexp, org or porcelain", *format)
+	}
+	if *format != "text" && *format != "json" && flag.Arg(0) != "" {
+		fatalf("--format %s cannot be used with %s command, use --json", *format, flag.Arg(0))
+	}
+	if *interactive && (*jsonOutput || *dryRun) {
+		fatal("--interactive cannot be used with --json, --format or --dry-run")
+	}
+	if *jsonOutput {
+		*colorMode = "never"
+	}
+	if err := SetColorMode(*colorMode); err != nil {
+		fatal(err)
+	}
+	if err := SetPalette(*themeName, themeColors); err != nil {
+		fatal(err)
+	}
+	var err error
+	if len(breakingFlags) > 0 {
+		if breaking, err = straightup.CompilePatterns(breakingFlags, false); err != nil {
+			fatalf("--breaking-pattern: %v", err)
+		}
+	}
+	if highlight, err = straightup.CompilePatterns(highlightFlags, true); err != nil {
+		fatalf("--highlight: %v", err)
+	}
+	if len(grepFlags) > 0 {
+		if grep, err = straightup.CompilePatterns(grepFlags, true); err != nil {
+			fatalf("--grep: %v", err)
+		}
+	}
+	var profiles []straightup.Profile
+	if *profileName != "" || *allProfiles {
+		if profiles, err = SelectProfiles(); err != nil {
+			fatal(err)
+		}
+	}
+	var dirs []string
+	if *profileName != "" {
+		dirs = []string{profiles[0].Dir}
+	}
+	if manager, err = ResolveManager(dirs); err != nil {
+		fatal(err)
+	}
+	if pins, err = LoadLockfile(); err != nil {
+		fatal(err)
+	}
+	if *groupBy != "none" && *groupBy != "author" {
+		fatalf("unknown --group-by %q, use none or author", *groupBy)
+	}
+	if commitTpl, err = LoadCommitTemplate(*templatePath); err != nil {
+		fatal(err)
+	}
+	if *webhookTemplate != "" {
+		if *webhook == "" {
+			fatal("--webhook-template requires --webhook")
+		}
+		if webhookTpl, err = LoadWebhookTemplate(*webhookTemplate); err != nil {
+			fatal(err)
+		}
 	}
 
-	wg := &sync.WaitGroup{}
-	for _, v := range repos {
-		wg.Add(1)
-		go UpdateEmacsStraightRepo(v, wg)
+	if *rebuild && *allProfiles {
+		fatal("--rebuild cannot be used with --all-profiles")
+	}
+	if writeLockfile != "" && (*dryRun || *check) {
+		fatal("--write-lockfile cannot be used with --dry-run or --check")
+	}
+	if writeLockfile != "" && writeLockfile != "true" && *allProfiles {
+		fatal("--write-lockfile=path cannot be used with --all-profiles, every profile has own lockfile")
+	}
+	if *watch < 0 {
+		fatal("--watch interval must be positive")
+	}
+	if *watch > 0 && (*interactive || flag.Arg(0) != "") {
+		fatal("--watch cannot be used with --interactive or commands")
+	}
+	if *allProfiles && flag.Arg(0) != "" {
+		fatalf("--all-profiles cannot be used with %s command, use --profile", flag.Arg(0))
+	}
+	switch flag.Arg(0) {
+	case "":
+	case "rollback":
+		runRollback(flag.Args()[1:])
+		return
+	case "preview":
+		runPreview(flag.Args()[1:])
+		return
+	case "list":
+		runList(flag.Args()[1:])
+		return
+	case "status":
+		runStatus(flag.Args()[1:])
+		return
+	case "log":
+		runLog(flag.Args()[1:])
+		return
+	case "reset-tags":
+		runResetTags(flag.Args()[1:])
+		return
+	case "freeze":
+		runFreeze(flag.Args()[1:])
+		return
+	case "restore":
+		runRestore(flag.Args()[1:])
+		return
+	case "history":
+		runHistory(flag.Args()[1:])
+		return
+	case "undo":
+		runUndo(flag.Args()[1:])
+		return
+	default:
+		fatalf("unknown command %q", flag.Arg(0))
 	}
 
-	wg.Wait()
-	if restartEmacsIsNeeded {
-		restartEmacs()
+	if *watch > 0 {
+		os.Exit(Watch(profiles))
+	}
+	ctx := InterruptContext()
+	LockRun(ctx)
+	_, code := runUpdate(ctx, profiles)
+	os.Exit(code)
+}
+
+// Update repos, of every profile with --all-profiles, report and record them
+// and restart Emacs for them; the reports and exit code of the run are
+// returned
+func runUpdate(ctx context.Context, profiles []straightup.Profile) ([]straightup.Result, int) {
+	start := time.Now()
+	var (
+		err      error
+		reports  []straightup.Result
+		failures []RepoError
+		excluded int
+		stop     atomic.Bool // set on the first failure with --fail-fast
+	)
+	if *allProfiles {
+		for _, prof := range profiles {
+			*reposDir = ""
+			// a bad profile is skipped, the others and next --watch cycles go on
+			if manager, err = ResolveManager([]string{prof.Dir}); err == nil {
+				pins, err = LoadLockfile()
+			}
+			if err != nil {
+				warn("profile", prof.Name, "skipped:", straightup.Scrub(err.Error()))
+				failures = append(failures, RepoError{prof.Dir, err})
+				continue
+			}
+			if fi, err := os.Stat(*reposDir); err != nil || !fi.IsDir() {
+				warn("profile", prof.Name, "has no repos directory", *reposDir+", skipped")
+				continue
+			}
+			if !*jsonOutput && !*check {
+				fmt.Println(output.String("Profile", prof.Name, "("+prof.Dir+")").Foreground(output.Color(palette.Header)).Bold().Underline())
+			}
+			repos, n := SelectRepos(only)
+			l, errs := UpdateRepos(ctx, repos, &stop)
+			SaveLockfile(l)
+			for i := range l {
+				l[i].Name = prof.Name + "/" + l[i].Name
+			}
+			reports, failures, excluded = append(reports, l...), append(failures, errs...), excluded+n
+		}
+	} else {
+		var repos []string
+		repos, excluded = SelectRepos(only)
+		reports, failures = UpdateRepos(ctx, repos, &stop)
+		SaveLockfile(reports)
+	}
+	if *feed && !*jsonOutput && !*check {
+		PrintFeed(reports)
+	}
+	if *reportPath != "" {
+		if err := SaveReport(reports, time.Since(start)); err != nil {
+			warn("cannot write report:", err.Error())
+		}
+	}
+	changed := UpdatedRepos(reports)
+	restart, _ := SplitRestartRepos(changed)
+	if *check {
+		behind := PendingRepos(reports)
+		if behind > 0 {
+			fmt.Println(behind, "repos behind")
+		}
+		if *verbose {
+			for _, v := range failures {
+				fmt.Fprintln(os.Stderr, v)
+			}
+		}
+		switch {
+		case interrupted.Load():
+			return reports, ExitInterrupted
+		case len(failures) > 0:
+			return reports, ExitFailed
+		case behind > 0:
+			return reports, ExitBehind
+		}
+		return reports, 0
+	}
+	if *jsonOutput {
+		total, _ := Downloaded(reports)
+		rr := RunReport{
+			RestartNeeded: len(restart) > 0,
+			RestartRepos:  restart,
+			Interrupted:   interrupted.Load(),
+			Excluded:      excluded,
+			Duration:      time.Since(start),
+			Downloaded:    total,
+			Repos:         reports,
+		}
+		switch *format {
+		case "sexp":
+			err = WriteSexp(os.Stdout, rr)
+		case "org":
+			err = RenderOrgReport(os.Stdout, reports, time.Now(), rr.Duration)
+		case "porcelain":
+			err = WritePorcelain(os.Stdout, reports)
+		default:
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false)
+			err = enc.Encode(rr)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if *notify {
+			Notify(reports, failures)
+		}
+		OpenCompare(reports)
+		err = RestartEmacsIfNeeded(WatchRestartRepos(changed))
+		if err != nil {
+			log.Print(err)
+		}
+		SaveHistory(reports, start)
+		SendWebhook(reports, time.Since(start))
+		return reports, RunExitCode(failures, err, changed)
+	}
+	if !*quiet {
+		PrintSummary(reports, *showUnchanged)
+		PrintTimings(reports, time.Since(start), *timings)
+		PrintDownloads(reports, *verbose)
+	}
+	if excluded > 0 && !*quiet {
+		fmt.Println(output.String(strconv.Itoa(excluded), "repos skipped by exclusion").Faint())
+	}
+	PrintFailures(failures)
+	PrintCounters(reports, time.Since(start))
+	if *notify {
+		Notify(reports, failures)
+	}
+	OpenCompare(reports)
+	if *dryRun {
+		if !*quiet {
+			fmt.Println(output.String(strconv.Itoa(PendingRepos(reports)), "repos have pending updates, nothing merged (dry run)").Faint())
+		}
+	} else if err = RestartEmacsIfNeeded(WatchRestartRepos(changed)); err != nil {
+		log.Print(err)
 	}
+	SaveHistory(reports, start)
+	SendWebhook(reports, time.Since(start))
+	return reports, RunExitCode(failures, err, changed)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Desktop notification command of the current OS, nil if it's unsupported
+func notifyCommand(ctx context.Context, title, body string, critical bool) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+		script := fmt.Sprintf("display notification \"%s\" with title \"%s\"", quote(body), quote(title))
+		if critical {
+			script += ` sound name "Basso"`
+		}
+		return exec.CommandContext(ctx, "osascript", "-e", script)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		urgency := "normal"
+		if critical {
+			urgency = "critical"
+		}
+		return exec.CommandContext(ctx, "notify-send", "--app-name=updstraight", "--urgency="+urgency, title, body)
+	}
+	return nil
+}
+
+// Send desktop notification summarizing the run, failure to send it is
+// reported only in verbose mode: there may be no notification daemon at all
+func Notify(reports []straightup.Result, failures []RepoError) {
+	var repos, commits int
+	for _, v := range reports {
+		switch v.Status {
+		case straightup.StatusUpdated, straightup.StatusPending, straightup.StatusForced:
+			repos++
+			commits += v.NewCommits
+		}
+	}
+
+	var body string
+	if *dryRun {
+		body = fmt.Sprintf("%d repos have pending updates, %d commits", repos, commits)
+	} else {
+		restart := "not needed"
+		if len(RestartTriggers(reports)) > 0 {
+			restart = "needed"
+		}
+		body = fmt.Sprintf("%d repos updated, %d commits, Emacs restart %s", repos, commits, restart)
+	}
+	if len(failures) > 0 {
+		names := make([]string, len(failures))
+		for i, v := range failures {
+			names[i] = filepath.Base(v.Path)
+		}
+		body += fmt.Sprintf("\n%d failed: %s", len(failures), strings.Join(names, ", "))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cmd := notifyCommand(ctx, "updstraight", body, len(failures) > 0)
+	if cmd == nil {
+		if *verbose {
+			warn("notification is not supported on", runtime.GOOS)
+		}
+		return
+	}
+	if out, err := cmd.CombinedOutput(); err != nil && *verbose {
+		warn("notification failed:", strings.TrimSpace(err.Error()+"\n"+string(out)))
+	}
+}
@@ -0,0 +1,274 @@
+package straightup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// Default private keys tried if there is no ssh-agent, in order
+var sshKeyFiles = []string{"id_ed25519", "id_rsa"}
+
+var (
+	sshSignersMu sync.Mutex
+	sshSigners   = make(map[string]ssh.Signer) // loaded private keys by path
+)
+
+// Asks passphrase of encrypted private key p, e.g. on terminal
+type PassphraseFunc func(p string) ([]byte, error)
+
+// Auth of remote: ssh-agent or private key for ssh remotes, token of
+// environment or .netrc login for http(s) remotes, nil (no auth) otherwise;
+// passphrase of encrypted key is asked by nw.Passphrase, if it's nil such
+// keys fail
+func RemoteAuth(rr *git.Remote, nw Network) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(rr.Config().URLs[0])
+	if err != nil {
+		return nil, ScrubError(err)
+	}
+	if ep.Protocol == "http" || ep.Protocol == "https" {
+		return httpAuth(ep, nw.TokenHosts)
+	}
+	if ep.Protocol != "ssh" {
+		return nil, nil
+	}
+	user := ep.User
+	if user == "" {
+		user = "git"
+	}
+	return sshAuth(user, nw.Passphrase)
+}
+
+// Auth by ssh-agent if SSH_AUTH_SOCK is set, by the first found default
+// private key otherwise, host keys are checked against known_hosts
+func sshAuth(user string, ask PassphraseFunc) (transport.AuthMethod, error) {
+	hostKeys, err := gitssh.NewKnownHostsCallback()
+	if err != nil {
+		return nil, fmt.Errorf("known_hosts: %w", err)
+	}
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		if auth, err := gitssh.NewSSHAgentAuth(user); err == nil {
+			auth.HostKeyCallback = hostKeys
+			return auth, nil
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sshKeyFiles {
+		signer, err := loadSSHKey(filepath.Join(home, ".ssh", name), ask)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		auth := &gitssh.PublicKeys{User: user, Signer: signer}
+		auth.HostKeyCallback = hostKeys
+		return auth, nil
+	}
+	return nil, errors.New("no ssh-agent (SSH_AUTH_SOCK) and no ~/.ssh/id_ed25519 or ~/.ssh/id_rsa key")
+}
+
+// Load private key once, ask for its passphrase if it's encrypted
+func loadSSHKey(p string, ask PassphraseFunc) (ssh.Signer, error) {
+	sshSignersMu.Lock()
+	defer sshSignersMu.Unlock()
+	if s, ok := sshSigners[p]; ok {
+		return s, nil
+	}
+
+	pem, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if ask == nil {
+			return nil, fmt.Errorf("%s is encrypted, add it to ssh-agent", p)
+		}
+		var pass []byte
+		if pass, err = ask(p); err != nil {
+			return nil, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, pass)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	sshSigners[p] = signer
+	return signer, nil
+}
+
+// Credentials of http(s) remote: login of the remote host in ~/.netrc,
+// UPDSTRAIGHT_TOKEN for tokenHosts (github.com if empty), GITHUB_TOKEN for
+// github.com, the latter two only over https; nil if nothing is found,
+// public repos need no auth
+func httpAuth(ep *transport.Endpoint, tokenHosts []string) (transport.AuthMethod, error) {
+	if ep.User != "" {
+		return nil, nil // credentials of URL are used by go-git
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	login, password, err := ReadNetrc(filepath.Join(home, ".netrc"), ep.Host)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		AddSecret(password)
+		return &githttp.BasicAuth{Username: login, Password: password}, nil
+	}
+	if ep.Protocol != "https" {
+		return nil, nil
+	}
+	if len(tokenHosts) == 0 {
+		tokenHosts = []string{"github.com"}
+	}
+	var token string
+	if slices.ContainsFunc(tokenHosts, func(h string) bool { return matchHost(ep.Host, h) }) {
+		token = os.Getenv("UPDSTRAIGHT_TOKEN")
+	}
+	if token == "" && matchHost(ep.Host, "github.com") {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token == "" {
+		return nil, nil
+	}
+	AddSecret(token)
+	// the user name is ignored by GitHub and most forges, but must be non-empty
+	return &githttp.BasicAuth{Username: "x-access-token", Password: token}, nil
+}
+
+// Report whether host is domain or its subdomain, case is ignored
+func matchHost(host, domain string) bool {
+	host, domain = strings.ToLower(host), strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// Find login and password of host in netrc file p, the default entry is used
+// if there is no entry of host; a missing file is not an error
+func ReadNetrc(p, host string) (login, password string, err error) {
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	type entry struct{ login, password string }
+	var (
+		found, def *entry
+		cur        *entry
+		fields     []string
+		macro      bool
+	)
+	for _, l := range strings.Split(string(b), "\n") {
+		// macro definition runs till an empty line
+		if macro {
+			macro = strings.TrimSpace(l) != ""
+			continue
+		}
+		f := strings.Fields(l)
+		if i := slices.Index(f, "macdef"); i >= 0 {
+			f, macro = f[:i], true
+		}
+		fields = append(fields, f...)
+	}
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch fields[i] {
+		case "machine":
+			cur = nil
+			if next() == host && found == nil {
+				found = &entry{}
+				cur = found
+			}
+		case "default":
+			cur = nil
+			if def == nil {
+				def = &entry{}
+				cur = def
+			}
+		case "login":
+			if v := next(); cur != nil {
+				cur.login = v
+			}
+		case "password":
+			if v := next(); cur != nil {
+				cur.password = v
+			}
+		case "account":
+			next()
+		}
+	}
+	if found == nil {
+		found = def
+	}
+	if found == nil {
+		return "", "", nil
+	}
+	return found.login, found.password, nil
+}
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string // tokens and passwords which are never printed
+
+	userinfoRe = regexp.MustCompile(`(://)[^/@\s]+@`)
+)
+
+// Remember secret to remove it from all printed messages
+func AddSecret(s string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if !slices.Contains(secrets, s) {
+		secrets = append(secrets, s)
+	}
+}
+
+// Remove credentials from text: userinfo of URLs and known secrets
+func Scrub(s string) string {
+	s = userinfoRe.ReplaceAllString(s, "${1}***@")
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, v := range secrets {
+		s = strings.ReplaceAll(s, v, "***")
+	}
+	return s
+}
+
+// Error with credentials removed from its message, errors without them are
+// returned as is, so comparisons with sentinel errors keep working
+func ScrubError(err error) error {
+	if err == nil || Scrub(err.Error()) == err.Error() {
+		return err
+	}
+	return scrubbedError{err}
+}
+
+type scrubbedError struct{ err error }
+
+func (e scrubbedError) Error() string { return Scrub(e.err.Error()) }
+func (e scrubbedError) Unwrap() error { return e.err }
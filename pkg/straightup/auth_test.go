@@ -0,0 +1,45 @@
+package straightup
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestHTTPAuthTokenHosts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // no .netrc
+	t.Setenv("UPDSTRAIGHT_TOKEN", "upd-secret")
+	t.Setenv("GITHUB_TOKEN", "gh-secret")
+
+	for _, tc := range []struct {
+		url   string
+		hosts []string
+		want  string
+	}{
+		{"https://github.com/magit/magit", nil, "upd-secret"},
+		{"https://codeberg.org/akib/emacs-eat", nil, ""},
+		{"https://git.savannah.gnu.org/git/emacs/org-mode.git", nil, ""},
+		{"https://gitlab.example.com/me/pkg", []string{"gitlab.example.com"}, "upd-secret"},
+		{"https://git.Example.com/me/pkg", []string{"example.com"}, "upd-secret"},
+		{"https://notexample.com/me/pkg", []string{"example.com"}, ""},
+		{"https://github.com/magit/magit", []string{"gitlab.example.com"}, "gh-secret"},
+		{"http://gitlab.example.com/me/pkg", []string{"gitlab.example.com"}, ""},
+	} {
+		ep, err := transport.NewEndpoint(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err := httpAuth(ep, tc.hosts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if auth != nil {
+			got = auth.(*githttp.BasicAuth).Password
+		}
+		if got != tc.want {
+			t.Errorf("token of %s with token hosts %v = %q, want %q", tc.url, tc.hosts, got, tc.want)
+		}
+	}
+}
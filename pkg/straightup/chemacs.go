@@ -0,0 +1,252 @@
+package straightup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Profiles files of chemacs2 in the order it reads them
+var ChemacsProfilesFiles = []string{"~/.emacs-profiles.el", "~/.config/chemacs/profiles.el"}
+
+// Emacs config of chemacs2 profile
+type Profile struct {
+	Name string
+	Dir  string // user-emacs-directory with ~ expanded
+}
+
+// Read profiles of the first existing chemacs2 profiles file, the path of
+// the file is returned too
+func ReadChemacsProfiles() ([]Profile, string, error) {
+	for _, v := range ChemacsProfilesFiles {
+		p, err := ExpandHome(v)
+		if err != nil {
+			return nil, "", err
+		}
+		b, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, p, err
+		}
+		l, err := ParseChemacsProfiles(p, string(b))
+		return l, p, err
+	}
+	return nil, "", fmt.Errorf("no chemacs2 profiles file, tried %s", strings.Join(ChemacsProfilesFiles, ", "))
+}
+
+// Find profile by name
+func FindProfile(l []Profile, name string) (Profile, bool) {
+	for _, v := range l {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Parse chemacs2 profiles alist of file p, e.g.
+//
+//	(("default" . ((user-emacs-directory . "~/.emacs.default")))
+//	 ("doom" . ((user-emacs-directory . "~/doom-emacs")
+//	            (env . (("DOOMDIR" . "~/doom-config"))))))
+func ParseChemacsProfiles(p, src string) ([]Profile, error) {
+	rd := &sexpReader{src: src, line: 1}
+	v, err := rd.read()
+	if err == nil {
+		rd.skipSpace()
+		if rd.pos < len(rd.src) {
+			err = rd.errorf("unexpected text after profiles list")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	entries, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: profiles are not a list", p)
+	}
+	var l []Profile
+	for _, e := range entries {
+		name, props, ok := sexpCons(e)
+		if !ok {
+			return nil, fmt.Errorf("%s: profile is not (name . settings): %v", p, e)
+		}
+		prof := Profile{}
+		if prof.Name, ok = name.(string); !ok {
+			return nil, fmt.Errorf("%s: profile name is not a string: %v", p, name)
+		}
+		for props != nil {
+			var kv any
+			if kv, props, ok = sexpCons(props); !ok {
+				break
+			}
+			k, val, ok := sexpCons(kv)
+			if !ok || k != sexpSymbol("user-emacs-directory") {
+				continue
+			}
+			if rest, ok := val.([]any); ok && len(rest) == 1 {
+				val = rest[0] // (user-emacs-directory "dir") form
+			}
+			dir, ok := val.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: user-emacs-directory of profile %q is not a string", p, prof.Name)
+			}
+			if prof.Dir, err = ExpandHome(dir); err != nil {
+				return nil, err
+			}
+		}
+		if prof.Dir == "" {
+			return nil, fmt.Errorf("%s: profile %q has no user-emacs-directory", p, prof.Name)
+		}
+		l = append(l, prof)
+	}
+	if len(l) == 0 {
+		return nil, fmt.Errorf("%s: no profiles", p)
+	}
+	return l, nil
+}
+
+// Symbol of elisp data
+type sexpSymbol string
+
+// Dotted list of elisp data: (items... . tail)
+type sexpDotted struct {
+	items []any
+	tail  any
+}
+
+// Split list to its first element and the rest
+func sexpCons(v any) (car, cdr any, ok bool) {
+	switch v := v.(type) {
+	case []any:
+		if len(v) == 0 {
+			return nil, nil, false
+		}
+		if len(v) == 1 {
+			return v[0], nil, true
+		}
+		return v[0], v[1:], true
+	case sexpDotted:
+		if len(v.items) == 1 {
+			return v.items[0], v.tail, true
+		}
+		return v.items[0], sexpDotted{v.items[1:], v.tail}, true
+	}
+	return nil, nil, false
+}
+
+// Reader of the subset of elisp data used by profiles files: lists, dotted
+// pairs, strings, symbols and comments
+type sexpReader struct {
+	src       string
+	pos, line int
+}
+
+func (rd *sexpReader) errorf(format string, v ...any) error {
+	return fmt.Errorf("line %d: %s", rd.line, fmt.Sprintf(format, v...))
+}
+
+func (rd *sexpReader) skipSpace() {
+	for rd.pos < len(rd.src) {
+		switch c := rd.src[rd.pos]; {
+		case c == ';':
+			for rd.pos < len(rd.src) && rd.src[rd.pos] != '\n' {
+				rd.pos++
+			}
+		case c == '\n':
+			rd.line++
+			rd.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			rd.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (rd *sexpReader) read() (any, error) {
+	rd.skipSpace()
+	if rd.pos >= len(rd.src) {
+		return nil, rd.errorf("unexpected end of file")
+	}
+	switch rd.src[rd.pos] {
+	case '(':
+		rd.pos++
+		return rd.readList()
+	case ')':
+		return nil, rd.errorf("unexpected )")
+	case '"':
+		return rd.readString()
+	case '\'':
+		rd.pos++ // quoted data reads the same
+		return rd.read()
+	}
+	start := rd.pos
+	for rd.pos < len(rd.src) && !strings.ContainsRune(" \t\r\n\f()\";", rune(rd.src[rd.pos])) {
+		rd.pos++
+	}
+	return sexpSymbol(rd.src[start:rd.pos]), nil
+}
+
+func (rd *sexpReader) readList() (any, error) {
+	var items []any
+	for {
+		rd.skipSpace()
+		if rd.pos >= len(rd.src) {
+			return nil, rd.errorf("unexpected end of file, missing )")
+		}
+		if rd.src[rd.pos] == ')' {
+			rd.pos++
+			return items, nil
+		}
+		v, err := rd.read()
+		if err != nil {
+			return nil, err
+		}
+		if v != sexpSymbol(".") {
+			items = append(items, v)
+			continue
+		}
+		if len(items) == 0 {
+			return nil, rd.errorf("dot at the start of list")
+		}
+		tail, err := rd.read()
+		if err != nil {
+			return nil, err
+		}
+		rd.skipSpace()
+		if rd.pos >= len(rd.src) || rd.src[rd.pos] != ')' {
+			return nil, rd.errorf("expected ) after dotted pair")
+		}
+		rd.pos++
+		if l, ok := tail.([]any); ok {
+			return append(items, l...), nil // (a . (b c)) is (a b c)
+		}
+		return sexpDotted{items, tail}, nil
+	}
+}
+
+func (rd *sexpReader) readString() (any, error) {
+	var b strings.Builder
+	for rd.pos++; rd.pos < len(rd.src); rd.pos++ {
+		switch c := rd.src[rd.pos]; c {
+		case '"':
+			rd.pos++
+			return b.String(), nil
+		case '\\':
+			if rd.pos++; rd.pos < len(rd.src) {
+				b.WriteByte(rd.src[rd.pos])
+			}
+		case '\n':
+			rd.line++
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, rd.errorf("unterminated string")
+}
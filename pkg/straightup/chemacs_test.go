@@ -0,0 +1,44 @@
+package straightup
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChemacsProfiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	src := `;; -*- mode: emacs-lisp -*-
+(("default" . ((user-emacs-directory . "~/.emacs.default")))
+ ("doom" . ((user-emacs-directory . "~/doom-emacs") ; comment
+            (server-name . "doom")
+            (env . (("DOOMDIR" . "~/doom \"config\"")))))
+ ("spacemacs" (user-emacs-directory "/opt/spacemacs")))
+`
+	l, err := ParseChemacsProfiles("profiles.el", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Profile{
+		{"default", filepath.Join(home, ".emacs.default")},
+		{"doom", filepath.Join(home, "doom-emacs")},
+		{"spacemacs", "/opt/spacemacs"},
+	}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("profiles = %+v, want %+v", l, want)
+	}
+
+	for _, tt := range []struct{ src, err string }{
+		{`(("doom" . ((user-emacs-directory . "~/doom")))`, "profiles.el: line 1: unexpected end of file"},
+		{"((\"doom\" . ((server-name . \"doom\"))))", `profile "doom" has no user-emacs-directory`},
+		{`((doom . ((user-emacs-directory . "~/doom"))))`, "profile name is not a string"},
+		{`(("doom" . ((user-emacs-directory . "~/doom))))`, "unterminated string"},
+		{"()", "no profiles"},
+	} {
+		if _, err := ParseChemacsProfiles("profiles.el", tt.src); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("error of %s = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package straightup
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// Files listed by compact --stat
+	statTopFiles = 5
+
+	// Updates touching more files are not diffed, only the files are counted
+	statMaxFiles = 1000
+)
+
+type FileStat struct {
+	Name       string `json:"name"`
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+	Binary     bool   `json:"binary,omitempty"`
+}
+
+type DiffStat struct {
+	Files      []FileStat `json:"files"`
+	Insertions int        `json:"insertions"`
+	Deletions  int        `json:"deletions"`
+	Summarized bool       `json:"summarized,omitempty"` // too many files, lines are not counted
+}
+
+// Compute files changed between two commits, sorted by number of changed lines
+func GetDiffStat(r *git.Repository, from, to plumbing.Hash) (*DiffStat, error) {
+	a, err := r.CommitObject(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.CommitObject(to)
+	if err != nil {
+		return nil, err
+	}
+	ta, err := a.Tree()
+	if err != nil {
+		return nil, err
+	}
+	tb, err := b.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(ta, tb)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &DiffStat{}
+	if len(changes) > statMaxFiles {
+		st.Summarized = true
+		for _, c := range changes {
+			name := c.To.Name
+			if name == "" {
+				name = c.From.Name
+			}
+			st.Files = append(st.Files, FileStat{Name: name})
+		}
+		slices.SortFunc(st.Files, func(a, b FileStat) int { return cmp.Compare(a.Name, b.Name) })
+		return st, nil
+	}
+
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, err
+	}
+	for _, fp := range patch.FilePatches() {
+		var fs FileStat
+		if f, t := fp.Files(); t != nil {
+			fs.Name = t.Path()
+		} else {
+			fs.Name = f.Path()
+		}
+		if fs.Binary = fp.IsBinary(); !fs.Binary {
+			for _, c := range fp.Chunks() {
+				n := strings.Count(c.Content(), "\n")
+				if !strings.HasSuffix(c.Content(), "\n") && c.Content() != "" {
+					n++
+				}
+				switch c.Type() {
+				case diff.Add:
+					fs.Insertions += n
+				case diff.Delete:
+					fs.Deletions += n
+				}
+			}
+		}
+		st.Insertions += fs.Insertions
+		st.Deletions += fs.Deletions
+		st.Files = append(st.Files, fs)
+	}
+	slices.SortStableFunc(st.Files, func(a, b FileStat) int {
+		return cmp.Or(cmp.Compare(b.Insertions+b.Deletions, a.Insertions+a.Deletions), cmp.Compare(a.Name, b.Name))
+	})
+	return st, nil
+}
+
+// Render diffstat: totals line and the most changed files, all of them if
+// FullStat of theme is set
+func RenderDiffStat(st *DiffStat, th Theme) string {
+	output := th.output()
+	var b strings.Builder
+	if st.Summarized {
+		fmt.Fprintln(&b, output.String(strconv.Itoa(len(st.Files)), "files changed").Bold())
+	} else {
+		fmt.Fprintln(&b,
+			output.String(strconv.Itoa(len(st.Files)), "files changed,").Bold(),
+			output.String(strconv.Itoa(st.Insertions), "insertions(+),").Foreground(output.Color(th.Palette.Insert)),
+			output.String(strconv.Itoa(st.Deletions), "deletions(-)").Foreground(output.Color(th.Palette.Delete)),
+		)
+	}
+
+	files := st.Files
+	if !th.FullStat && len(files) > statTopFiles {
+		files = files[:statTopFiles]
+	}
+	width := 0
+	for _, f := range files {
+		width = max(width, len(f.Name))
+	}
+	for _, f := range files {
+		name := fmt.Sprintf("  %-*s", width, f.Name)
+		switch {
+		case st.Summarized:
+			fmt.Fprintln(&b, name)
+		case f.Binary:
+			fmt.Fprintln(&b, name, output.String("binary").Faint())
+		default:
+			fmt.Fprintln(&b, name,
+				output.String("+"+strconv.Itoa(f.Insertions)).Foreground(output.Color(th.Palette.Insert)),
+				output.String("-"+strconv.Itoa(f.Deletions)).Foreground(output.Color(th.Palette.Delete)),
+			)
+		}
+	}
+	if n := len(st.Files) - len(files); n > 0 {
+		fmt.Fprintln(&b, output.String("  ...", strconv.Itoa(n), "more").Faint())
+	}
+	return b.String()
+}
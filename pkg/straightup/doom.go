@@ -0,0 +1,49 @@
+package straightup
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Path of doom CLI of Emacs config directory dir, empty if dir is not the one
+// of Doom Emacs
+func DoomBin(dir string) string {
+	p := filepath.Join(dir, "bin", "doom")
+	if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+		return ""
+	}
+	return p
+}
+
+// Straight repos directory of Doom Emacs config directory dir: straight/repos
+// of $DOOMLOCALDIR or .local of dir; builds of some Doom versions keep repos
+// in subdirectories by Emacs version, the most recently modified one having
+// repos is picked then
+func DoomReposDir(dir string) string {
+	local := filepath.Join(dir, ".local")
+	if d := os.Getenv("DOOMLOCALDIR"); d != "" {
+		if d, err := ExpandHome(d); err == nil {
+			local = d
+		}
+	}
+	p := filepath.Join(local, "straight", "repos")
+	repos, other, err := ScanReposDir(p)
+	if err != nil || len(repos) > 0 {
+		return p
+	}
+	var newest string
+	var mtime int64
+	for _, v := range other {
+		fi, err := os.Stat(v)
+		if err != nil || !fi.IsDir() || fi.ModTime().UnixNano() <= mtime {
+			continue
+		}
+		if l, _, err := ScanReposDir(v); err == nil && len(l) > 0 {
+			newest, mtime = v, fi.ModTime().UnixNano()
+		}
+	}
+	if newest != "" {
+		return newest
+	}
+	return p
+}
@@ -0,0 +1,64 @@
+package straightup
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Changes of remote fetched by FetchRepo ahead of the update, which merges
+// them without network access then
+type Fetched struct {
+	Tags []string              // tag names before the fetch, for new releases
+	Refs []*plumbing.Reference // advertised by remote, e.g. its HEAD
+	Took time.Duration
+	Size int64 // of received packfiles, bytes
+	Err  error // of the fetch, the update fails with it
+}
+
+// Fetch remote of repo p into its remote-tracking refs and tags, branches
+// and worktree are not touched; nil is returned for repos the update does not
+// fetch from upstream (pinned, fresh, local, detached and empty ones) and for the
+// ones which fail to open, the update reports them
+func FetchRepo(parent context.Context, p string, o Options) *Fetched {
+	ctx, cancel := o.networkContext(parent)
+	defer cancel()
+
+	start := time.Now()
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return nil
+	}
+	head, err := r.Head()
+	if err != nil || o.Pinned != "" || !head.Name().IsBranch() && !o.UpdateDetached {
+		return nil
+	}
+	if _, ok := o.fresh(r); ok {
+		return nil
+	}
+	rr, err := UpstreamRemote(r, head)
+	if err != nil {
+		return nil
+	}
+
+	f := &Fetched{}
+	size := PackedSize(r)
+	if f.Tags, f.Err = TagNames(r); f.Err == nil {
+		f.Refs, f.Err = ListRemoteRefs(ctx, rr, o.Network)
+	}
+	if f.Err == nil {
+		f.Err = FetchGitChanges(ctx, rr, o.Network)
+	}
+	if f.Err == nil && !o.DryRun {
+		f.Err = FetchGitTags(ctx, rr, o.Network)
+	}
+	f.Took, f.Size = time.Since(start), max(PackedSize(r)-size, 0)
+	if f.Err != nil {
+		o.debug(p, start, "fetch failed: ", f.Err)
+	} else {
+		o.debug(p, start, "fetched ", rr.Config().Name)
+	}
+	return f
+}
@@ -0,0 +1,137 @@
+package straightup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Time of the first fixture commit, every next commit is a minute later, so
+// commits are ordered by committer time without sleeping
+var epoch = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Git repo of test with the time of its next commit
+type fixture struct {
+	t    *testing.T
+	r    *git.Repository
+	dir  string // empty for in-memory repo
+	when time.Time
+}
+
+// In-memory repo with the initial commit
+func newMemFixture(t *testing.T) *fixture {
+	t.Helper()
+	r, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{t: t, r: r, when: epoch}
+	f.commit("init")
+	return f
+}
+
+// Upstream repo in temp directory with the initial commit, to be cloned by
+// clone as local filesystem remote
+func newUpstream(t *testing.T) *fixture {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "remote")
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{t: t, r: r, dir: dir, when: epoch}
+	f.commit("init")
+	return f
+}
+
+// Commit change of pkg.el with message msg, return the commit hash
+func (f *fixture) commit(msg string) plumbing.Hash {
+	f.t.Helper()
+	return f.commitFile("pkg.el", ";; "+msg+"\n", msg)
+}
+
+// Commit file name with given content and message msg, return the commit hash
+func (f *fixture) commitFile(name, content, msg string) plumbing.Hash {
+	f.t.Helper()
+	w, err := f.r.Worktree()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if err = util.WriteFile(w.Filesystem, name, []byte(content), 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+	if _, err = w.Add(name); err != nil {
+		f.t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "Tester", Email: "tester@example.com", When: f.when}
+	h, err := w.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.when = f.when.Add(time.Minute)
+	return h
+}
+
+// Commit n changes, return their hashes in commit order
+func (f *fixture) commits(n int) []plumbing.Hash {
+	f.t.Helper()
+	l := make([]plumbing.Hash, n)
+	for i := range l {
+		l[i] = f.commit("change " + string(rune('a'+i)))
+	}
+	return l
+}
+
+// Clone upstream as straight repo name, return path of the clone
+func (f *fixture) clone(name string) string {
+	f.t.Helper()
+	p := filepath.Join(f.t.TempDir(), "repos", name)
+	if _, err := git.PlainClone(p, false, &git.CloneOptions{URL: f.dir}); err != nil {
+		f.t.Fatal(err)
+	}
+	return p
+}
+
+// HEAD of repo p
+func head(t *testing.T, p string) plumbing.Hash {
+	t.Helper()
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.Hash()
+}
+
+// Commit of update marker of repo p, zero hash if there is no marker
+func marked(t *testing.T, p string) plumbing.Hash {
+	t.Helper()
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := MarkedCommit(r)
+	if err == ErrNoMarker {
+		return plumbing.ZeroHash
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.Hash()
+}
+
+// Write file name of repo p with content s
+func writeFile(p, name, s string) error {
+	return os.WriteFile(filepath.Join(p, name), []byte(s), 0o644)
+}
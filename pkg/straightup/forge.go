@@ -0,0 +1,117 @@
+package straightup
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/muesli/termenv"
+)
+
+// Forges of known web URL schemes
+const (
+	forgeGitHub    = "github"
+	forgeGitLab    = "gitlab"
+	forgeGitea     = "gitea"
+	forgeBitbucket = "bitbucket"
+	forgeSourcehut = "sourcehut"
+)
+
+// Web URL of repo of remote URL and its forge, empty for unknown hosts; ssh
+// (scp-like too) and https remotes with and without .git are recognized
+func forgeRepo(remote string) (base, forge string) {
+	host, path := remoteHostPath(remote)
+	if !strings.Contains(path, "/") {
+		return "", ""
+	}
+	switch {
+	case host == "github.com":
+		forge = forgeGitHub
+	case host == "gitlab.com", strings.HasPrefix(host, "gitlab."):
+		forge = forgeGitLab
+	case host == "codeberg.org":
+		forge = forgeGitea
+	case host == "bitbucket.org":
+		forge = forgeBitbucket
+	case host == "git.sr.ht":
+		forge = forgeSourcehut
+	default:
+		return "", ""
+	}
+	return "https://" + host + "/" + path, forge
+}
+
+// Web page of commit hash on the forge of remote URL: GitHub, GitLab,
+// Codeberg, Bitbucket or sourcehut, empty for unknown hosts
+func CommitURL(remote, hash string) string {
+	base, forge := forgeRepo(remote)
+	switch {
+	case hash == "", forge == "":
+		return ""
+	case forge == forgeGitLab:
+		return base + "/-/commit/" + hash
+	case forge == forgeBitbucket:
+		return base + "/commits/" + hash
+	}
+	return base + "/commit/" + hash
+}
+
+// Web page comparing commits from and to on the forge of remote URL, empty
+// for hosts without compare pages (sourcehut) and unknown ones
+func CompareURL(remote, from, to string) string {
+	base, forge := forgeRepo(remote)
+	if from == "" || to == "" {
+		return ""
+	}
+	switch forge {
+	case forgeGitHub, forgeGitea:
+		return base + "/compare/" + from + "..." + to
+	case forgeGitLab:
+		return base + "/-/compare/" + from + "..." + to
+	case forgeBitbucket:
+		return base + "/branches/compare/" + to + "%0D" + from
+	}
+	return ""
+}
+
+// Host and repo path of remote URL without leading slash and .git suffix
+func remoteHostPath(remote string) (host, path string) {
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", ""
+		}
+		host, path = u.Hostname(), u.Path
+	} else {
+		// scp-like syntax of ssh: [user@]host:path
+		var ok bool
+		if host, path, ok = strings.Cut(remote, ":"); !ok {
+			return "", ""
+		}
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return strings.ToLower(host), path
+}
+
+// URL of local path p
+func FileURL(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") { // drive letter of Windows
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// Wrap text into OSC 8 hyperlink to link, empty link leaves text as is
+func hyperlink(link, text string) string {
+	if link == "" {
+		return text
+	}
+	return termenv.Hyperlink(link, text)
+}
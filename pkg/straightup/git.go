@@ -0,0 +1,726 @@
+package straightup
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// Ref marking the position of repo before the last update, it's hidden
+// from tag listings and not pushed by default refspecs
+const MarkerRef plumbing.ReferenceName = "refs/updstraight/last-update"
+
+// Ref of the upstream commit of the last update, the rewrite of upstream
+// history is detected by it
+const SyncedRef plumbing.ReferenceName = "refs/updstraight/synced"
+
+// Legacy tag of the update marker, it's still read if repo has no MarkerRef
+// and moved there by MigrateGitTag
+const TagName = "Updated.At"
+
+// Settings of remote operations
+type Network struct {
+	Depth      int            // fetch only N recent commits, 0 means full history
+	Retries    int            // retries of transient network failures
+	Proxy      string         // proxy URL, empty means proxy of environment
+	Passphrase PassphraseFunc // asks passphrase of encrypted ssh key, may be nil
+	TokenHosts []string       // https hosts UPDSTRAIGHT_TOKEN is sent to, github.com if empty
+	Log        io.Writer      // verbose log of operations, nil if disabled
+}
+
+// Version of updstraight recorded in update marker annotation
+var Version = "unknown"
+
+// Repo was never updated, it has neither update marker nor legacy tag
+var ErrNoMarker = errors.New("no update marker")
+
+// Point update marker to the commit of ref by a new annotated tag object,
+// its message records the time of the update, the commit marked before and
+// Version; reference of the marker to the commit is returned
+func SetUpdateMarker(r *git.Repository, ref *plumbing.Reference) (*plumbing.Reference, error) {
+	previous := "none"
+	switch old, err := MarkedCommit(r); err {
+	case nil:
+		previous = old.Hash().String()
+	case ErrNoMarker:
+	default:
+		return nil, err
+	}
+	now := time.Now()
+	tag := &object.Tag{
+		Name:       MarkerRef.Short(),
+		Tagger:     object.Signature{Name: "updstraight", Email: "updstraight@localhost", When: now},
+		Message:    fmt.Sprintf("Update marker of updstraight\n\nUpdated: %s\nPrevious: %s\nVersion: %s\n", now.Format(time.RFC3339), previous, Version),
+		TargetType: plumbing.CommitObject,
+		Target:     ref.Hash(),
+	}
+	obj := r.Storer.NewEncodedObject()
+	if err := tag.Encode(obj); err != nil {
+		return nil, err
+	}
+	h, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.Storer.SetReference(plumbing.NewHashReference(MarkerRef, h)); err != nil {
+		return nil, err
+	}
+	return plumbing.NewHashReference(MarkerRef, ref.Hash()), nil
+}
+
+// Delete update marker and legacy tag of repo, missing ones are ignored
+func DeleteUpdateMarker(r *git.Repository) error {
+	if err := r.Storer.RemoveReference(MarkerRef); err != nil {
+		return err
+	}
+	if err := r.DeleteTag(TagName); err != nil && err != git.ErrTagNotFound {
+		return err
+	}
+	return nil
+}
+
+// Move legacy Updated.At tag of repo to update marker, the marker is kept if
+// it's set already; report whether there was the tag to migrate
+func MigrateGitTag(r *git.Repository) (bool, error) {
+	tag, err := r.Tag(TagName)
+	if err == git.ErrTagNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch _, err = r.Reference(MarkerRef, false); err {
+	case plumbing.ErrReferenceNotFound:
+		if err = r.Storer.SetReference(plumbing.NewHashReference(MarkerRef, tag.Hash())); err != nil {
+			return false, err
+		}
+	case nil:
+	default:
+		return false, err
+	}
+	return true, r.DeleteTag(TagName)
+}
+
+// Commit which hash h of marker or tag points to: annotated tag objects are
+// peeled to their target, other hashes are returned as is
+func PeelCommit(r *git.Repository, h plumbing.Hash) plumbing.Hash {
+	for {
+		t, err := r.TagObject(h)
+		if err != nil {
+			return h
+		}
+		h = t.Target
+	}
+}
+
+// Reference of update marker to the marked commit, of legacy Updated.At tag
+// if repo has no marker yet; ErrNoMarker if there is neither of them
+func MarkedCommit(r *git.Repository) (*plumbing.Reference, error) {
+	ref, err := r.Reference(MarkerRef, false)
+	if err == plumbing.ErrReferenceNotFound {
+		ref, err = r.Tag(TagName)
+		if err == git.ErrTagNotFound {
+			return nil, ErrNoMarker
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plumbing.NewHashReference(ref.Name(), PeelCommit(r, ref.Hash())), nil
+}
+
+// Time of the last update by tagger date of update marker, zero time for
+// lightweight legacy tags and repos without marker
+func MarkerTime(r *git.Repository) time.Time {
+	ref, err := r.Reference(MarkerRef, false)
+	if err == plumbing.ErrReferenceNotFound {
+		ref, err = r.Tag(TagName)
+	}
+	if err != nil {
+		return time.Time{}
+	}
+	tag, err := r.TagObject(ref.Hash())
+	if err != nil {
+		return time.Time{}
+	}
+	return tag.Tagger.When
+}
+
+// List names of repo tags, except the legacy Updated.At tag
+func TagNames(r *git.Repository) (names []string, err error) {
+	iter, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if n := ref.Name().Short(); n != TagName {
+			names = append(names, n)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return
+}
+
+// Repo has no remotes, e.g. a local package
+var ErrNoRemote = errors.New("local-only repo, nothing to pull")
+
+// Tracking config of the branch checked out at head, nil if not configured
+func TrackingBranch(r *git.Repository, head *plumbing.Reference) *config.Branch {
+	if head == nil || !head.Name().IsBranch() {
+		return nil
+	}
+	b, err := r.Branch(head.Name().Short())
+	if err != nil || b.Remote == "" {
+		return nil
+	}
+	return b
+}
+
+// Remote to update repo from: the remote tracked by the branch checked out
+// at head, origin or the first remote by name
+func UpstreamRemote(r *git.Repository, head *plumbing.Reference) (*git.Remote, error) {
+	if b := TrackingBranch(r, head); b != nil {
+		if rr, err := r.Remote(b.Remote); err == nil {
+			return rr, nil
+		}
+	}
+	rr, err := r.Remote(git.DefaultRemoteName)
+	if err != git.ErrRemoteNotFound {
+		return rr, err
+	}
+	remotes, err := r.Remotes()
+	if err != nil {
+		return nil, err
+	}
+	if len(remotes) == 0 {
+		return nil, ErrNoRemote
+	}
+	slices.SortFunc(remotes, func(a, b *git.Remote) int { return cmp.Compare(a.Config().Name, b.Config().Name) })
+	return remotes[0], nil
+}
+
+// Find the remote-tracking ref of the local branch
+func RemoteTrackingRef(r *git.Repository, remote string, branch plumbing.ReferenceName) (*plumbing.Reference, error) {
+	if !branch.IsBranch() {
+		return nil, fmt.Errorf("HEAD is not a branch: %s", branch)
+	}
+	return r.Reference(plumbing.NewRemoteReferenceName(remote, branch.Short()), true)
+}
+
+// Remote-tracking branch of remote HEAD, i.e. the default branch, guess
+// master or main if remote HEAD is unknown
+func DefaultRemoteBranch(r *git.Repository, remote string) (*plumbing.Reference, error) {
+	ref, err := r.Reference(plumbing.NewRemoteHEADReferenceName(remote), true)
+	if err == nil {
+		return ref, nil
+	}
+	for _, b := range []string{"master", "main"} {
+		if ref, err = r.Reference(plumbing.NewRemoteReferenceName(remote, b), true); err == nil {
+			return ref, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find the default branch of %s", remote)
+}
+
+// Pinned branch if it's not empty, the merge ref tracked by the branch
+// checked out at head or the branch itself otherwise
+func ConfiguredBranch(r *git.Repository, pinned string, head *plumbing.Reference) plumbing.ReferenceName {
+	if pinned != "" {
+		return plumbing.NewBranchReferenceName(pinned)
+	}
+	if b := TrackingBranch(r, head); b != nil && b.Merge.IsBranch() {
+		return b.Merge
+	}
+	return head.Name()
+}
+
+// Check out local branch, if it's missing create it from the remote-tracking
+// branch and set up tracking, return the new HEAD
+func SwitchBranch(ctx context.Context, r *git.Repository, rr *git.Remote, branch plumbing.ReferenceName, nw Network) (*plumbing.Reference, error) {
+	_, err := r.Reference(branch, false)
+	switch err {
+	case nil:
+	case plumbing.ErrReferenceNotFound:
+		if err = FetchGitChanges(ctx, rr, nw); err != nil {
+			return nil, err
+		}
+		remote, err := RemoteTrackingRef(r, rr.Config().Name, branch)
+		if err != nil {
+			return nil, fmt.Errorf("no branch %s on %s: %w", branch.Short(), rr.Config().Name, err)
+		}
+		err = r.CreateBranch(&config.Branch{Name: branch.Short(), Remote: rr.Config().Name, Merge: branch})
+		if err != nil && err != git.ErrBranchExists {
+			return nil, err
+		}
+		if err = r.Storer.SetReference(plumbing.NewHashReference(branch, remote.Hash())); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	if err = w.Checkout(&git.CheckoutOptions{Branch: branch}); err != nil {
+		return nil, fmt.Errorf("checkout %s: %w", branch.Short(), err)
+	}
+	return r.Head()
+}
+
+// Pull git changes of remote branch (remote HEAD if empty) and return true
+// if the local workdir has updated; errors of the pull are returned as is,
+// git.ErrNonFastForwardUpdate if the local branch has own commits and
+// upstream has new ones, the branch only ahead of upstream is up-to-date
+func PullGitChanges(ctx context.Context, r *git.Repository, rr *git.Remote, branch plumbing.ReferenceName, nw Network) (bool, error) {
+	head, err := r.Head()
+	if err != nil {
+		return false, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	auth, err := RemoteAuth(rr, nw)
+	if err != nil {
+		return false, err
+	}
+	proxy, err := RemoteProxy(rr, nw.Proxy)
+	if err != nil {
+		return false, err
+	}
+	err = Retry(ctx, rr.Config().URLs[0], nw.Retries, nw.Log, func() error {
+		return w.PullContext(ctx, &git.PullOptions{RemoteName: rr.Config().Name, ReferenceName: branch, Depth: nw.Depth, Auth: auth, ProxyOptions: proxy})
+	})
+	if ctx.Err() != nil { // deadline exceeded, the pull is incomplete
+		return false, ctx.Err()
+	}
+	switch {
+	case err == nil:
+		// fetch of other refs is reported as update too, compare HEAD
+		cur, err := r.Head()
+		if err != nil {
+			return false, err
+		}
+		return cur.Hash() != head.Hash(), nil
+	case errors.Is(err, git.NoErrAlreadyUpToDate):
+		return false, nil
+	case errors.Is(err, git.ErrNonFastForwardUpdate):
+		upstream, uerr := RemoteTrackingRef(r, rr.Config().Name, cmp.Or(branch, head.Name()))
+		if uerr != nil {
+			return false, err
+		}
+		if ok, aerr := IsAncestor(r, upstream.Hash(), head.Hash()); aerr == nil && ok {
+			return false, nil // only ahead of upstream
+		}
+		return false, err
+	default:
+		// go-git moves the branch before the worktree update fails, e.g. on
+		// unstaged changes, move it back to the files of the worktree
+		if cur, herr := r.Head(); herr == nil && cur.Hash() != head.Hash() {
+			if serr := r.Storer.SetReference(plumbing.NewHashReference(cur.Name(), head.Hash())); serr != nil {
+				return false, errors.Join(err, serr)
+			}
+		}
+		return false, err
+	}
+}
+
+// Size of packfiles of repo, every fetch of go-git writes the received pack
+// as is, so the growth is the number of downloaded bytes; 0 for storages
+// other than filesystem
+func PackedSize(r *git.Repository) int64 {
+	s, ok := r.Storer.(*filesystem.Storage)
+	if !ok {
+		return 0
+	}
+	fs := s.Filesystem()
+	l, err := fs.ReadDir(fs.Join("objects", "pack"))
+	if err != nil {
+		return 0
+	}
+	var n int64
+	for _, v := range l {
+		if strings.HasSuffix(v.Name(), ".pack") {
+			n += v.Size()
+		}
+	}
+	return n
+}
+
+// Fast-forward the checked out branch to the fetched remote-tracking branch
+// of remote without network access, like pull after fetch; return true if the
+// worktree has updated, git.ErrNonFastForwardUpdate if the local branch has
+// own commits
+func FastForwardGitChanges(r *git.Repository, remote string, branch plumbing.ReferenceName) (bool, error) {
+	head, err := r.Head()
+	if err != nil {
+		return false, err
+	}
+	upstream, err := RemoteTrackingRef(r, remote, branch)
+	if err != nil {
+		return false, err
+	}
+	if ok, err := IsAncestor(r, upstream.Hash(), head.Hash()); err != nil || ok {
+		return false, err // up-to-date or only ahead of upstream
+	}
+	if ok, err := IsAncestor(r, head.Hash(), upstream.Hash()); err != nil {
+		return false, err
+	} else if !ok {
+		return false, git.ErrNonFastForwardUpdate
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	if err = r.Storer.SetReference(plumbing.NewHashReference(head.Name(), upstream.Hash())); err != nil {
+		return false, err
+	}
+	return true, w.Reset(&git.ResetOptions{Commit: upstream.Hash(), Mode: git.MergeReset})
+}
+
+// Fetch changes of remote into its remote-tracking refs, the worktree is not touched
+func FetchGitChanges(ctx context.Context, rr *git.Remote, nw Network) error {
+	auth, err := RemoteAuth(rr, nw)
+	if err != nil {
+		return err
+	}
+	proxy, err := RemoteProxy(rr, nw.Proxy)
+	if err != nil {
+		return err
+	}
+	err = Retry(ctx, rr.Config().URLs[0], nw.Retries, nw.Log, func() error {
+		return rr.FetchContext(ctx, &git.FetchOptions{Depth: nw.Depth, Auth: auth, ProxyOptions: proxy})
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// Fetch all tags of remote
+func FetchGitTags(ctx context.Context, rr *git.Remote, nw Network) error {
+	auth, err := RemoteAuth(rr, nw)
+	if err != nil {
+		return err
+	}
+	proxy, err := RemoteProxy(rr, nw.Proxy)
+	if err != nil {
+		return err
+	}
+	err = Retry(ctx, rr.Config().URLs[0], nw.Retries, nw.Log, func() error {
+		return rr.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs:     []config.RefSpec{"+refs/tags/*:refs/tags/*"},
+			Tags:         git.AllTags,
+			Depth:        nw.Depth,
+			Auth:         auth,
+			ProxyOptions: proxy,
+		})
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// Merge upstream ref into the branch checked out in repo p with git, go-git
+// can only fast-forward; the merge is aborted on conflicts
+func MergeGitChanges(ctx context.Context, p string, ref plumbing.ReferenceName) error {
+	out, err := exec.CommandContext(ctx, "git", "-C", p, "merge", "--no-edit", ref.String()).CombinedOutput()
+	if err != nil {
+		exec.Command("git", "-C", p, "merge", "--abort").Run()
+		return fmt.Errorf("git merge %s: %w: %s", ref.Short(), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// Rebase has failed and was aborted, the local commits are kept as they were
+var ErrRebaseAborted = errors.New("rebase aborted")
+
+// Replay local commits of the branch checked out in repo p onto upstream ref
+// with git, go-git cannot rebase; a failed rebase is aborted and HEAD must
+// be back at orig
+func RebaseGitChanges(ctx context.Context, r *git.Repository, p string, ref plumbing.ReferenceName, orig plumbing.Hash) error {
+	out, err := exec.CommandContext(ctx, "git", "-C", p, "rebase", ref.String()).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if aerr := exec.Command("git", "-C", p, "rebase", "--abort").Run(); aerr != nil {
+		return fmt.Errorf("git rebase %s: %w, abort failed: %w", ref.Short(), err, aerr)
+	}
+	if head, herr := r.Head(); herr != nil || head.Hash() != orig {
+		return fmt.Errorf("git rebase %s: %w, HEAD was not restored to %s", ref.Short(), err, orig)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return fmt.Errorf("%w, %s does not apply cleanly: %s", ErrRebaseAborted, ref.Short(), lines[len(lines)-1])
+}
+
+// Fetch remote and hard-reset the checked out branch and the worktree to its
+// upstream branch, return the discarded local commits and whether HEAD has moved
+func ForceResetGitRepo(ctx context.Context, r *git.Repository, rr *git.Remote, branch plumbing.ReferenceName, nw Network) (discarded []plumbing.Hash, updated bool, err error) {
+	if err = FetchGitChanges(ctx, rr, nw); err != nil {
+		return
+	}
+	upstream, err := RemoteTrackingRef(r, rr.Config().Name, branch)
+	if err != nil {
+		return
+	}
+	head, err := r.Head()
+	if err != nil {
+		return
+	}
+	if discarded, err = UniqueCommits(r, head.Hash(), upstream.Hash()); err != nil {
+		return
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return
+	}
+	if err = w.Reset(&git.ResetOptions{Commit: upstream.Hash(), Mode: git.HardReset}); err != nil {
+		return
+	}
+	return discarded, head.Hash() != upstream.Hash(), nil
+}
+
+// Upstream commit of the last update: of SyncedRef, of the remote-tracking
+// branch of remote yet to be fetched if repo has no such ref, of head if
+// there is no remote-tracking branch either
+func SyncedCommit(r *git.Repository, remote string, branch plumbing.ReferenceName, head *plumbing.Reference) plumbing.Hash {
+	if ref, err := r.Reference(SyncedRef, false); err == nil {
+		return ref.Hash()
+	}
+	if ref, err := RemoteTrackingRef(r, remote, branch); err == nil {
+		return ref.Hash()
+	}
+	return head.Hash()
+}
+
+// Point SyncedRef to the remote-tracking branch of remote the repo is
+// updated to, nothing is done if there is no such branch
+func SetSyncedCommit(r *git.Repository, remote string, branch plumbing.ReferenceName) error {
+	ref, err := RemoteTrackingRef(r, remote, branch)
+	if err != nil {
+		return nil
+	}
+	return r.Storer.SetReference(plumbing.NewHashReference(SyncedRef, ref.Hash()))
+}
+
+// Report whether commit a is an ancestor of commit b or the same commit
+func IsAncestor(r *git.Repository, a, b plumbing.Hash) (bool, error) {
+	ca, err := r.CommitObject(a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := r.CommitObject(b)
+	if err != nil {
+		return false, err
+	}
+	return ca.IsAncestor(cb)
+}
+
+// Report whether upstream history is rewritten: the synced commit of the last
+// update is not an ancestor of the fetched one; synced commit missing in
+// shallow history is taken as not rewritten
+func IsRewritten(r *git.Repository, synced, fetched plumbing.Hash) (bool, error) {
+	ok, err := IsAncestor(r, synced, fetched)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+	return !ok, err
+}
+
+// Refs advertised by remote, its HEAD is a symbolic ref if remote tells it
+func ListRemoteRefs(ctx context.Context, rr *git.Remote, nw Network) (refs []*plumbing.Reference, err error) {
+	auth, err := RemoteAuth(rr, nw)
+	if err != nil {
+		return
+	}
+	proxy, err := RemoteProxy(rr, nw.Proxy)
+	if err != nil {
+		return
+	}
+	err = Retry(ctx, rr.Config().URLs[0], nw.Retries, nw.Log, func() (err error) {
+		refs, err = rr.ListContext(ctx, &git.ListOptions{Auth: auth, ProxyOptions: proxy})
+		return
+	})
+	return
+}
+
+// Default branch of remote by its HEAD, empty if remote does not tell it, and
+// whether branch exists on remote
+func RemoteDefaultBranch(ctx context.Context, rr *git.Remote, branch plumbing.ReferenceName, nw Network) (def plumbing.ReferenceName, exists bool, err error) {
+	refs, err := ListRemoteRefs(ctx, rr, nw)
+	if err != nil {
+		return
+	}
+	def, exists = defaultBranchOf(refs, branch)
+	return
+}
+
+// Default branch by HEAD of remote refs and whether branch is one of them
+func defaultBranchOf(refs []*plumbing.Reference, branch plumbing.ReferenceName) (def plumbing.ReferenceName, exists bool) {
+	for _, ref := range refs {
+		switch {
+		case ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference:
+			def = ref.Target()
+		case ref.Name() == branch:
+			exists = true
+		}
+	}
+	return
+}
+
+// New default branch of remote if it's renamed away from fetched branch, e.g.
+// master → main: the branch is gone from remote or it's left behind by the
+// default one; empty if branch is the default or it still advances on its own
+func DefaultBranchRenamed(ctx context.Context, r *git.Repository, rr *git.Remote, branch plumbing.ReferenceName, nw Network) (plumbing.ReferenceName, error) {
+	refs, err := ListRemoteRefs(ctx, rr, nw)
+	if err != nil {
+		return "", err
+	}
+	return renamedDefaultBranch(r, rr.Config().Name, branch, refs)
+}
+
+// Renamed default branch of remote by its listed refs, see DefaultBranchRenamed
+func renamedDefaultBranch(r *git.Repository, remote string, branch plumbing.ReferenceName, refs []*plumbing.Reference) (plumbing.ReferenceName, error) {
+	def, exists := defaultBranchOf(refs, branch)
+	if def == "" || def == branch {
+		return "", nil
+	}
+	if !exists {
+		return def, nil
+	}
+	old, err := RemoteTrackingRef(r, remote, branch)
+	if err != nil {
+		return "", nil
+	}
+	tip, err := RemoteTrackingRef(r, remote, def)
+	if err != nil {
+		return "", nil
+	}
+	if ok, err := IsAncestor(r, old.Hash(), tip.Hash()); err != nil || !ok {
+		return "", err
+	}
+	return def, nil
+}
+
+// Commits reachable from a but not from b, newest first
+func UniqueCommits(r *git.Repository, a, b plumbing.Hash) ([]plumbing.Hash, error) {
+	ca, err := r.CommitObject(a)
+	if err != nil {
+		return nil, err
+	}
+	cb, err := r.CommitObject(b)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := ca.MergeBase(cb)
+	if err != nil {
+		return nil, err
+	}
+	shared := make(map[plumbing.Hash]bool)
+	for _, c := range bases {
+		err = object.NewCommitPreorderIter(c, shared, nil).ForEach(func(c *object.Commit) error {
+			shared[c.Hash] = true
+			return nil
+		})
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return nil, err
+		}
+	}
+	var l []plumbing.Hash
+	err = object.NewCommitPreorderIter(ca, shared, nil).ForEach(func(c *object.Commit) error {
+		l = append(l, c.Hash)
+		return nil
+	})
+	if err == plumbing.ErrObjectNotFound {
+		err = nil
+	}
+	return l, err
+}
+
+// Count commits reachable from a but not from b (ahead) and reachable from b
+// but not from a (behind), set truncated if the history is cut by shallow fetch
+func AheadBehind(r *git.Repository, a, b plumbing.Hash) (ahead, behind int, truncated bool, err error) {
+	ca, err := r.CommitObject(a)
+	if err != nil {
+		return
+	}
+	cb, err := r.CommitObject(b)
+	if err != nil {
+		return
+	}
+	bases, err := ca.MergeBase(cb)
+	if err != nil {
+		return
+	}
+
+	// history shared by both tips, walks below stop at it
+	shared := make(map[plumbing.Hash]bool)
+	for _, c := range bases {
+		err = object.NewCommitPreorderIter(c, shared, nil).ForEach(func(c *object.Commit) error {
+			shared[c.Hash] = true
+			return nil
+		})
+		if err == plumbing.ErrObjectNotFound {
+			truncated, err = true, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	count := func(c *object.Commit) (n int, err error) {
+		err = object.NewCommitPreorderIter(c, shared, nil).ForEach(func(*object.Commit) error {
+			n++
+			return nil
+		})
+		if err == plumbing.ErrObjectNotFound {
+			truncated, err = true, nil
+		}
+		return
+	}
+	if ahead, err = count(ca); err != nil {
+		return
+	}
+	behind, err = count(cb)
+	return
+}
+
+// List tracked files of worktree with uncommitted changes
+func DirtyFiles(w *git.Worktree) (files []string, err error) {
+	st, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	for f, s := range st {
+		if s.Worktree == git.Untracked && s.Staging == git.Untracked {
+			continue
+		}
+		if s.Worktree != git.Unmodified || s.Staging != git.Unmodified {
+			files = append(files, f)
+		}
+	}
+	return
+}
@@ -0,0 +1,241 @@
+package straightup
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestSetUpdateMarker(t *testing.T) {
+	f := newMemFixture(t)
+	first, err := f.r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marker, err := SetUpdateMarker(f.r, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.Hash() != first.Hash() {
+		t.Errorf("marker set at %s, want %s", marker.Hash(), first.Hash())
+	}
+
+	second := plumbing.NewHashReference(plumbing.HEAD, f.commit("second"))
+	if marker, err = SetUpdateMarker(f.r, second); err != nil {
+		t.Fatal(err)
+	}
+	if marker.Hash() != second.Hash() {
+		t.Errorf("marker moved to %s, want %s", marker.Hash(), second.Hash())
+	}
+	ref, err := f.r.Reference(MarkerRef, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := PeelCommit(f.r, ref.Hash()); h != second.Hash() {
+		t.Errorf("stored marker at %s, want %s", h, second.Hash())
+	}
+	// annotation of moved marker records the commit marked before
+	obj, err := f.r.TagObject(ref.Hash())
+	if err != nil {
+		t.Fatalf("%s is not annotated: %v", MarkerRef, err)
+	}
+	if want := "Previous: " + first.Hash().String(); !strings.Contains(obj.Message, want) || obj.Tagger.Name != "updstraight" {
+		t.Errorf("marker annotation by %s:\n%s\nwant %q", obj.Tagger.Name, obj.Message, want)
+	}
+
+	// log since the annotated marker starts after the marked commit
+	third := plumbing.NewHashReference(plumbing.HEAD, f.commit("third"))
+	if commits, _, err := CollectGitLog(f.r, ref, third); err != nil || len(commits) != 1 || commits[0].Hash != third.Hash() {
+		t.Errorf("log since the marker = %v, %v, want the third commit only", commits, err)
+	}
+	// the marker is not a tag
+	if iter, err := f.r.Tags(); err != nil {
+		t.Fatal(err)
+	} else if err = iter.ForEach(func(ref *plumbing.Reference) error {
+		t.Errorf("update marker has created tag %s", ref.Name())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = DeleteUpdateMarker(f.r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MarkedCommit(f.r); err != ErrNoMarker {
+		t.Errorf("deleted marker lookup = %v, want %v", err, ErrNoMarker)
+	}
+}
+
+func TestMigrateGitTag(t *testing.T) {
+	f := newMemFixture(t)
+	first, err := f.r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.r.CreateTag(TagName, first.Hash(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the legacy tag is read until it's migrated
+	if ref, err := MarkedCommit(f.r); err != nil || ref.Hash() != first.Hash() {
+		t.Errorf("legacy marker = %v, %v, want %s", ref, err, first.Hash())
+	}
+	if ok, err := MigrateGitTag(f.r); err != nil || !ok {
+		t.Fatalf("MigrateGitTag() = %v, %v, want migrated", ok, err)
+	}
+	if _, err = f.r.Tag(TagName); err != git.ErrTagNotFound {
+		t.Errorf("legacy tag lookup after migration = %v, want %v", err, git.ErrTagNotFound)
+	}
+	if ref, err := MarkedCommit(f.r); err != nil || ref.Name() != MarkerRef || ref.Hash() != first.Hash() {
+		t.Errorf("migrated marker = %v, %v, want %s at %s", ref, err, MarkerRef, first.Hash())
+	}
+	if ok, err := MigrateGitTag(f.r); err != nil || ok {
+		t.Errorf("repeated MigrateGitTag() = %v, %v, want nothing to migrate", ok, err)
+	}
+	if names, err := TagNames(f.r); err != nil || len(names) != 0 {
+		t.Errorf("TagNames() = %v, %v, want no tags", names, err)
+	}
+}
+
+// Open clone p with its origin remote
+func openClone(t *testing.T, p string) (*git.Repository, *git.Remote) {
+	t.Helper()
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := r.Remote("origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, rr
+}
+
+func TestPullGitChanges(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	r, rr := openClone(t, p)
+	ctx := context.Background()
+
+	updated, err := PullGitChanges(ctx, r, rr, "", Network{})
+	if err != nil || updated {
+		t.Fatalf("pull of fresh clone = %v, %v, want no update", updated, err)
+	}
+
+	l := up.commits(2)
+	updated, err = PullGitChanges(ctx, r, rr, "", Network{})
+	if err != nil || !updated {
+		t.Fatalf("pull of new commits = %v, %v, want update", updated, err)
+	}
+	if h := head(t, p); h != l[1] {
+		t.Errorf("HEAD is %s after pull, want %s", h, l[1])
+	}
+
+	updated, err = PullGitChanges(ctx, r, rr, "", Network{})
+	if err != nil || updated {
+		t.Errorf("repeated pull = %v, %v, want no update", updated, err)
+	}
+}
+
+func TestPullGitChangesErrors(t *testing.T) {
+	t.Run("missing remote", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, rr := openClone(t, p)
+		if err := os.RemoveAll(up.dir); err != nil {
+			t.Fatal(err)
+		}
+		if updated, err := PullGitChanges(context.Background(), r, rr, "", Network{}); err == nil || updated {
+			t.Errorf("pull of removed remote = %v, %v, want error", updated, err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, rr := openClone(t, p)
+		up.commit("pending")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		updated, err := PullGitChanges(ctx, r, rr, "", Network{})
+		if err != context.Canceled || updated {
+			t.Errorf("pull with canceled context = %v, %v, want %v", updated, err, context.Canceled)
+		}
+	})
+
+	t.Run("diverged", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, rr := openClone(t, p)
+		up.commit("upstream")
+		local := &fixture{t: t, r: r, dir: p, when: up.when}
+		local.commit("local")
+		updated, err := PullGitChanges(context.Background(), r, rr, "", Network{})
+		if err != git.ErrNonFastForwardUpdate || updated {
+			t.Errorf("pull of diverged branch = %v, %v, want %v", updated, err, git.ErrNonFastForwardUpdate)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, rr := openClone(t, p)
+		up.commit("pending")
+		ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+		defer cancel()
+		updated, err := PullGitChanges(ctx, r, rr, "", Network{})
+		if err != context.DeadlineExceeded || updated {
+			t.Errorf("pull past deadline = %v, %v, want %v", updated, err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("conflicting worktree", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, rr := openClone(t, p)
+		up.commit("upstream")
+		if err := writeFile(p, "pkg.el", ";; local\n"); err != nil {
+			t.Fatal(err)
+		}
+		before := head(t, p)
+		if updated, err := PullGitChanges(context.Background(), r, rr, "", Network{}); err == nil || updated {
+			t.Errorf("pull into dirty worktree = %v, %v, want error", updated, err)
+		}
+		if h := head(t, p); h != before {
+			t.Errorf("HEAD = %s after failed pull, want %s", h, before)
+		}
+	})
+
+	t.Run("ahead", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, rr := openClone(t, p)
+		local := &fixture{t: t, r: r, dir: p, when: up.when}
+		local.commit("local")
+		if updated, err := PullGitChanges(context.Background(), r, rr, "", Network{}); err != nil || updated {
+			t.Errorf("pull of branch ahead of upstream = %v, %v, want false, nil", updated, err)
+		}
+	})
+
+	t.Run("other refs fetched", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, rr := openClone(t, p)
+		h := up.commit("feature")
+		if err := up.r.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", h)); err != nil {
+			t.Fatal(err)
+		}
+		if err := up.r.Storer.SetReference(plumbing.NewHashReference("refs/heads/master", head(t, p))); err != nil {
+			t.Fatal(err)
+		}
+		if updated, err := PullGitChanges(context.Background(), r, rr, plumbing.NewBranchReferenceName("master"), Network{}); err != nil || updated {
+			t.Errorf("pull with new upstream branch only = %v, %v, want false, nil", updated, err)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package straightup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Version lockfile of straight.el relative to the parent of repos directory
+const LockfileName = "versions/default.el"
+
+// Path of straight.el version lockfile of repos directory
+func LockfilePath(reposDir string) string {
+	return filepath.Join(filepath.Dir(reposDir), filepath.FromSlash(LockfileName))
+}
+
+// Read straight.el version lockfile p, nil without error if there is no such
+// file
+func ReadLockfile(p string) (map[string]string, error) {
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseLockfile(p, string(b))
+}
+
+// Parse straight.el version lockfile of file p: alist of repo names to commit
+// hashes followed by version keyword of the format, e.g.
+//
+//	(("magit" . "4992c3d1f64e0e983692c7a61d47069f47380dbf")
+//	 ("dash.el" . "1de9dcb83eacfb162b6d9a118a4770b1281bcd84"))
+//	:gamma
+func ParseLockfile(p, src string) (map[string]string, error) {
+	rd := &sexpReader{src: src, line: 1}
+	v, err := rd.read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	entries, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: versions are not a list", p)
+	}
+	pins := make(map[string]string, len(entries))
+	for _, e := range entries {
+		name, hash, ok := sexpCons(e)
+		if !ok {
+			return nil, fmt.Errorf("%s: version is not (repo . hash): %v", p, e)
+		}
+		n, ok1 := name.(string)
+		h, ok2 := hash.(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s: version is not (repo . hash) of strings: %v", p, e)
+		}
+		pins[n] = h
+	}
+	return pins, nil
+}
+
+// Format version lockfile like straight-freeze-versions does: alist sorted
+// by repo name and the keyword of the format
+func FormatLockfile(pins map[string]string) []byte {
+	names := make([]string, 0, len(pins))
+	for k := range pins {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	var buf bytes.Buffer
+	buf.WriteString(";; Version lockfile of straight.el, restore with M-x straight-thaw-versions\n(")
+	for i, k := range names {
+		if i > 0 {
+			buf.WriteString("\n ")
+		}
+		fmt.Fprintf(&buf, "(%s . %s)", elispString(k), elispString(pins[k]))
+	}
+	buf.WriteString(")\n:gamma\n")
+	return buf.Bytes()
+}
+
+// Quote s as elisp string
+func elispString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
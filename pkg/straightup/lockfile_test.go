@@ -0,0 +1,52 @@
+package straightup
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLockfile(t *testing.T) {
+	src := `(("dash.el" . "1de9dcb83eacfb162b6d9a118a4770b1281bcd84")
+ ("magit" . "4992c3d1f64e0e983692c7a61d47069f47380dbf"))
+:gamma
+`
+	pins, err := ParseLockfile("default.el", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"dash.el": "1de9dcb83eacfb162b6d9a118a4770b1281bcd84",
+		"magit":   "4992c3d1f64e0e983692c7a61d47069f47380dbf",
+	}
+	if !reflect.DeepEqual(pins, want) {
+		t.Errorf("pins = %v, want %v", pins, want)
+	}
+
+	if _, err = ParseLockfile("default.el", `(("magit" . 42))`); err == nil || !strings.Contains(err.Error(), "default.el") {
+		t.Errorf("error of hash which is not a string = %v", err)
+	}
+	if pins, err = ReadLockfile(filepath.Join(t.TempDir(), "default.el")); pins != nil || err != nil {
+		t.Errorf("missing lockfile = %v, %v, want nothing", pins, err)
+	}
+	if p := LockfilePath("/home/u/.emacs.d/straight/repos"); p != "/home/u/.emacs.d/straight/versions/default.el" {
+		t.Errorf("lockfile path = %s", p)
+	}
+}
+
+func TestFormatLockfile(t *testing.T) {
+	pins := map[string]string{"magit": "4992c3d", "dash.el": "1de9dcb", `odd"name`: "abc"}
+	b := FormatLockfile(pins)
+	want := `(("dash.el" . "1de9dcb")
+ ("magit" . "4992c3d")
+ ("odd\"name" . "abc"))
+:gamma
+`
+	if !strings.HasSuffix(string(b), "\n"+want) {
+		t.Errorf("lockfile is\n%s\nwant it to end with\n%s", b, want)
+	}
+	if l, err := ParseLockfile("default.el", string(b)); err != nil || !reflect.DeepEqual(l, pins) {
+		t.Errorf("parsed lockfile = %v, %v, want %v", l, err, pins)
+	}
+}
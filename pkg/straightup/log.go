@@ -0,0 +1,438 @@
+package straightup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/muesli/termenv"
+)
+
+// Built-in template of commit rendering
+const DefaultCommitTemplate = `{{"\t"}}{{ .Committer.When.Format "2006-01-02" | Color .Palette.Date }} {{ slice .Hash.String 0 6 | Color .Palette.Hash | Hyperlink (CommitURL .Remote .Hash.String) }} {{ Color .Palette.Author .Author.String }}
+{{"\t"}}{{"\t"}}{{ replaceAll .Message "\n" "\n\t\t" | Color .Palette.Message }}
+`
+
+// Built-in template of commit matching Breaking patterns of theme
+const DefaultBreakingTemplate = `{{"\t"}}{{ Color .Palette.Breaking "⚠" }} {{ .Committer.When.Format "2006-01-02" | Color .Palette.Date }} {{ slice .Hash.String 0 6 | Color .Palette.Hash | Hyperlink (CommitURL .Remote .Hash.String) }} {{ Color .Palette.Author .Author.String }}
+{{"\t"}}{{"\t"}}{{ replaceAll .Message "\n" "\n\t\t" | Color .Palette.Breaking | Bold }}
+`
+
+// Built-in template of commit matching Highlight patterns of theme
+const DefaultHighlightTemplate = `{{"\t"}}{{ Color .Palette.Highlight "»" }} {{ .Committer.When.Format "2006-01-02" | Color .Palette.Date }} {{ slice .Hash.String 0 6 | Color .Palette.Hash | Hyperlink (CommitURL .Remote .Hash.String) }} {{ Color .Palette.Author .Author.String }}
+{{"\t"}}{{"\t"}}{{ replaceAll .Message "\n" "\n\t\t" | Color .Palette.Highlight }}
+`
+
+// Built-in template of feed line, the repo name is colored by its own color
+const DefaultFeedTemplate = `{{ .Committer.When.Format "2006-01-02 15:04" | Color .Palette.Date }} {{ printf "%-20s" .Repo | Color .RepoColor }} {{ slice .Hash.String 0 6 | Color .Palette.Hash | Hyperlink (CommitURL .Remote .Hash.String) }} {{ Color .Palette.Message .Subject }}
+`
+
+// Look of rendered results
+type Theme struct {
+	Output          *termenv.Output    // color profile of the terminal, nil means no colors
+	Commit          *template.Template // template of commit, nil means DefaultCommitTemplate
+	Feed            *template.Template // template of feed line, nil means DefaultFeedTemplate
+	BreakingCommit  *template.Template // template of breaking commit, nil means DefaultBreakingTemplate
+	Breaking        Patterns           // breaking changes in commit messages, nil disables highlighting
+	OnlyBreaking    bool               // render only breaking commits and the repos having them
+	Highlight       Patterns           // commits rendered by highlight template
+	HighlightCommit *template.Template // template of highlighted commit, nil means DefaultHighlightTemplate
+	Grep            Patterns           // render only commits matching any of them, nil means all
+	GroupByAuthor   bool               // render commits of every author under its own header
+	MaxLog          int                // render at most N commits of repo, 0 means unlimited
+	Brief           bool               // render one line per updated repo instead of its commits
+	FullStat        bool               // list every changed file of diffstat, not the most changed only
+	Hyperlinks      bool               // link commit hashes to forge pages and paths to files by OSC 8 sequences
+	Palette         Palette            // colors of rendered elements, zero value leaves them uncolored
+
+	remote string // URL of the remote of rendered repo, for links of commits
+}
+
+func (th Theme) output() *termenv.Output {
+	if th.Output == nil {
+		return termenv.NewOutput(io.Discard, termenv.WithProfile(termenv.Ascii))
+	}
+	return th.Output
+}
+
+// Report whether commit passes OnlyBreaking and Grep filters of theme
+func (th Theme) visible(c *object.Commit) bool {
+	if th.OnlyBreaking && !th.Breaking.Match(c.Message) {
+		return false
+	}
+	return th.Grep == nil || th.Grep.Match(c.Message)
+}
+
+// Commit rendered by commit template
+type LogCommit struct {
+	*object.Commit
+	Remote  string  // URL of the remote of repo, empty if hyperlinks are disabled
+	Palette Palette // colors of theme
+}
+
+// Parse commit template, termenv color helpers of out, replaceAll,
+// CommitURL and Hyperlink are available
+func NewCommitTemplate(out *termenv.Output, name, text string) (*template.Template, error) {
+	return template.New(name).
+		Funcs(out.TemplateFuncs()).
+		Funcs(template.FuncMap{"replaceAll": strings.ReplaceAll, "CommitURL": CommitURL, "Hyperlink": hyperlink}).
+		Parse(text)
+}
+
+// Short form of hash, dash if it's unknown
+func ShortHash(h string) string {
+	if len(h) < 6 {
+		return "-"
+	}
+	return h[:6]
+}
+
+// Walk commits reachable from tip which are not reachable from the commit of
+// ref, i.e. git log ref..tip: new commits are found by ancestry, not by their
+// dates, so rebased or cherry-picked ones with old dates are not missed;
+// history cut by shallow fetch is reported as truncated instead of error;
+// nil ref means no lower bound, the whole history of tip is walked
+func walkLog(r *git.Repository, ref, tip *plumbing.Reference, f func(c *object.Commit) error) (truncated bool, err error) {
+	seen := make(map[plumbing.Hash]bool)
+	if ref != nil {
+		c, err := r.CommitObject(PeelCommit(r, ref.Hash()))
+		switch err {
+		case nil:
+			// hide the commit of ref and all its ancestors
+			err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+				seen[c.Hash] = true
+				return nil
+			})
+			if err != nil && err != plumbing.ErrObjectNotFound {
+				return false, err
+			}
+		case plumbing.ErrObjectNotFound: // beyond shallow boundary, show what is available
+			truncated = true
+		default:
+			return false, err
+		}
+	}
+
+	c, err := r.CommitObject(tip.Hash())
+	if err != nil {
+		return false, err
+	}
+	cIter := object.NewCommitPreorderIter(c, seen, nil)
+	defer cIter.Close()
+
+	err = cIter.ForEach(f)
+	if err == plumbing.ErrObjectNotFound {
+		return true, nil
+	}
+	return truncated, err
+}
+
+// Commits shown of rewritten upstream
+const rewrittenCommits = 10
+
+// Collect at most n commits reachable from tip which are not reachable from
+// the commit of ref, newest first
+func recentCommits(r *git.Repository, ref, tip *plumbing.Reference, n int) (commits []*object.Commit, err error) {
+	_, err = walkLog(r, ref, tip, func(c *object.Commit) error {
+		if len(commits) == n {
+			return storer.ErrStop
+		}
+		commits = append(commits, c)
+		return nil
+	})
+	return
+}
+
+// Print git log to buffer, inspect commits reachable from tip but not from ref,
+// count the number of commits and save to n
+func GetGitLog(r *git.Repository, ref, tip *plumbing.Reference, n *int, th Theme) (string, error) {
+	commits, truncated, err := CollectGitLog(r, ref, tip)
+	*n = len(commits)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = RenderGitLog(&buf, commits, truncated, th)
+	return buf.String(), err
+}
+
+// Collect commits reachable from tip which are newer than the commit of ref,
+// newest first
+func CollectGitLog(r *git.Repository, ref, tip *plumbing.Reference) (commits []*object.Commit, truncated bool, err error) {
+	truncated, err = walkLog(r, ref, tip, func(c *object.Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	return
+}
+
+// Render commits to buffer by commit template, at most MaxLog of them; with
+// GroupByAuthor every author gets a header and at most MaxLog of own commits
+func RenderGitLog(buf *bytes.Buffer, commits []*object.Commit, truncated bool, th Theme) error {
+	groups := [][]*object.Commit{commits}
+	if th.GroupByAuthor {
+		groups = GroupByAuthor(commits)
+	}
+	for _, l := range groups {
+		if th.GroupByAuthor {
+			if !slices.ContainsFunc(l, th.visible) {
+				continue
+			}
+			fmt.Fprintln(buf, "\t"+th.output().String(l[0].Author.Name, "—", strconv.Itoa(len(l)), "commits").Foreground(th.output().Color(th.Palette.Author)).Bold().String())
+		}
+		var n int
+		f, done, err := renderCommit(buf, &n, th)
+		if err != nil {
+			return err
+		}
+		for _, c := range l {
+			if err = f(c); err != nil {
+				return err
+			}
+		}
+		done()
+	}
+	if truncated {
+		fmt.Fprintln(buf, th.output().String("\t… history is truncated by shallow fetch, the list may be incomplete").Faint())
+	}
+	return nil
+}
+
+// Split commits by author name and email, the author having the most commits
+// goes first, ties keep the order of the newest commits of authors
+func GroupByAuthor(commits []*object.Commit) [][]*object.Commit {
+	var groups [][]*object.Commit
+	idx := make(map[string]int)
+	for _, c := range commits {
+		k := c.Author.Name + " <" + strings.ToLower(c.Author.Email) + ">"
+		i, ok := idx[k]
+		if !ok {
+			i, idx[k] = len(groups), len(groups)
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], c)
+	}
+	slices.SortStableFunc(groups, func(a, b []*object.Commit) int { return len(b) - len(a) })
+	return groups
+}
+
+// Print git log to buffer, inspect commits reachable from tip since given time,
+// count the number of commits and save to n
+func GetGitLogSince(r *git.Repository, tip *plumbing.Reference, since time.Time, n *int, th Theme) (string, error) {
+	cIter, err := r.Log(&git.LogOptions{From: tip.Hash(), Since: &since})
+	if err != nil {
+		return "", err
+	}
+	defer cIter.Close()
+
+	var commits []*object.Commit
+	err = cIter.ForEach(func(c *object.Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	*n = len(commits)
+	truncated := err == plumbing.ErrObjectNotFound
+	if err != nil && !truncated {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = RenderGitLog(&buf, commits, truncated, th)
+	return buf.String(), err
+}
+
+// Function rendering every single commit to buffer by commit template and
+// counting them in n, commits matching Breaking or Highlight patterns are
+// rendered by their own templates, the ones not matching Grep are skipped;
+// done writes note about commits hidden by MaxLog
+func renderCommit(buf *bytes.Buffer, n *int, th Theme) (f func(c *object.Commit) error, done func(), err error) {
+	tpl, breaking, highlight := th.Commit, th.BreakingCommit, th.HighlightCommit
+	for _, v := range []struct {
+		tpl        **template.Template
+		name, text string
+	}{
+		{&tpl, "tpl", DefaultCommitTemplate},
+		{&breaking, "breaking", DefaultBreakingTemplate},
+		{&highlight, "highlight", DefaultHighlightTemplate},
+	} {
+		if *v.tpl == nil {
+			if *v.tpl, err = NewCommitTemplate(th.output(), v.name, v.text); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	var shown, hidden int
+	remote := th.remote
+	if !th.Hyperlinks {
+		remote = ""
+	}
+	f = func(c *object.Commit) error {
+		*n++
+		if !th.visible(c) {
+			return nil
+		}
+		if shown++; th.MaxLog > 0 && shown > th.MaxLog {
+			hidden++
+			return nil // keep counting
+		}
+		lc := LogCommit{c, remote, th.Palette}
+		switch {
+		case th.Breaking.Match(c.Message):
+			return breaking.Execute(buf, lc)
+		case th.Highlight.Match(c.Message):
+			return highlight.Execute(buf, lc)
+		}
+		return tpl.Execute(buf, lc)
+	}
+	return f, func() { moreCommits(buf, hidden, th) }, nil
+}
+
+// Note about n commits not rendered due to MaxLog
+func moreCommits(buf *bytes.Buffer, n int, th Theme) {
+	if n > 0 {
+		fmt.Fprintln(buf, th.output().String("\t… and", strconv.Itoa(n), "more commits").Faint())
+	}
+}
+
+// Patterns of commit messages, e.g. of breaking changes
+type Patterns []*regexp.Regexp
+
+// Patterns of breaking changes: BREAKING CHANGE footers, "!:" markers of
+// conventional commits like feat!:, incompatible and deprecated changes
+var DefaultBreakingPatterns = Patterns{
+	regexp.MustCompile(`BREAKING`),
+	regexp.MustCompile(`!:`),
+	regexp.MustCompile(`(?i)incompatible`),
+	regexp.MustCompile(`(?i)deprecat`),
+}
+
+// Compile regexps of patterns, with ignoreCase they match case-insensitive
+// unless a pattern turns it off by (?-i)
+func CompilePatterns(l []string, ignoreCase bool) (Patterns, error) {
+	ps := make(Patterns, len(l))
+	for i, v := range l {
+		expr := v
+		if ignoreCase {
+			expr = "(?i)" + v
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", v, err)
+		}
+		ps[i] = re
+	}
+	return ps, nil
+}
+
+// Report whether text s matches any of patterns
+func (ps Patterns) Match(s string) bool {
+	for _, re := range ps {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit of repo update, used by JSON output
+type CommitInfo struct {
+	Hash     string    `json:"hash"`
+	Author   string    `json:"author"`
+	Date     time.Time `json:"date"`
+	Message  string    `json:"message"`
+	Breaking bool      `json:"breaking,omitempty"` // looks like breaking change
+}
+
+// Commits of repo update for JSON output
+func NewCommitInfos(commits []*object.Commit) []CommitInfo {
+	l := make([]CommitInfo, len(commits))
+	for i, c := range commits {
+		l[i] = CommitInfo{
+			Hash:    c.Hash.String(),
+			Author:  c.Author.String(),
+			Date:    c.Committer.When,
+			Message: c.Message,
+		}
+	}
+	return l
+}
+
+// Restore new commits of rep from its Commits, e.g. of result decoded from
+// JSON, to render the result again without the repo; only hashes, authors,
+// dates and messages are known
+func (rep *Result) RestoreLog() {
+	rep.log = make([]*object.Commit, len(rep.Commits))
+	for i, v := range rep.Commits {
+		name, email, _ := strings.Cut(v.Author, " <")
+		sig := object.Signature{Name: name, Email: strings.TrimSuffix(email, ">"), When: v.Date}
+		rep.log[i] = &object.Commit{Hash: plumbing.NewHash(v.Hash), Author: sig, Committer: sig, Message: v.Message}
+	}
+}
+
+// Commit of feed merged from results of several repos
+type FeedCommit struct {
+	*object.Commit
+	Repo      string // name of the repo
+	RepoColor string // color of the repo name by Palette.FeedColor, set by RenderFeed
+	Subject   string // first line of the message
+	Remote    string // URL of the remote of repo, empty if hyperlinks are disabled
+	Palette   Palette
+}
+
+// Merge new commits of results into one feed sorted by committer date, newest first
+func Feed(results []Result) []FeedCommit {
+	var feed []FeedCommit
+	for _, v := range results {
+		for _, c := range v.log {
+			subject, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+			feed = append(feed, FeedCommit{Commit: c, Repo: v.Name, Subject: subject, Remote: v.RemoteURL})
+		}
+	}
+	slices.SortStableFunc(feed, func(a, b FeedCommit) int {
+		return b.Committer.When.Compare(a.Committer.When)
+	})
+	return feed
+}
+
+// Render feed by feed template of theme, at most MaxLog commits matching Grep
+func RenderFeed(w io.Writer, feed []FeedCommit, th Theme) error {
+	tpl := th.Feed
+	if tpl == nil {
+		var err error
+		if tpl, err = NewCommitTemplate(th.output(), "feed", DefaultFeedTemplate); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	var shown int
+	for _, c := range feed {
+		if th.Grep != nil && !th.Grep.Match(c.Message) {
+			continue
+		}
+		if shown++; th.MaxLog > 0 && shown > th.MaxLog {
+			continue
+		}
+		if !th.Hyperlinks {
+			c.Remote = ""
+		}
+		c.RepoColor = th.Palette.FeedColor(c.Repo)
+		c.Palette = th.Palette
+		if err := tpl.Execute(&buf, c); err != nil {
+			return err
+		}
+	}
+	if th.MaxLog > 0 {
+		moreCommits(&buf, shown-th.MaxLog, th)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,362 @@
+package straightup
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestGetGitLog(t *testing.T) {
+	f := newMemFixture(t)
+	l := f.commits(4)
+	tag := plumbing.NewHashReference(plumbing.HEAD, l[1])
+	tip := plumbing.NewHashReference(plumbing.HEAD, l[3])
+
+	var n int
+	out, err := GetGitLog(f.r, tag, tip, &n, Theme{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tagged commit itself is hidden, only the ones after it are listed
+	if n != 2 {
+		t.Errorf("counted %d commits, want 2", n)
+	}
+	for _, msg := range []string{"change c", "change d"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("log misses %q:\n%s", msg, out)
+		}
+	}
+	for _, msg := range []string{"change b", "change a", "init"} {
+		if strings.Contains(out, msg) {
+			t.Errorf("log has %q of tagged commit or before:\n%s", msg, out)
+		}
+	}
+}
+
+func TestGetGitLogRebased(t *testing.T) {
+	f := newMemFixture(t)
+	l := f.commits(2)
+	tag := plumbing.NewHashReference(plumbing.HEAD, l[1])
+	// commits of rebased or imported history keep their old dates
+	f.when = epoch.AddDate(-1, 0, 0)
+	rebased := f.commits(2)
+	tip := plumbing.NewHashReference(plumbing.HEAD, rebased[1])
+
+	commits, truncated, err := CollectGitLog(f.r, tag, tip)
+	if err != nil || truncated {
+		t.Fatal(err, truncated)
+	}
+	if len(commits) != 2 || commits[0].Hash != rebased[1] || commits[1].Hash != rebased[0] {
+		t.Errorf("log of commits with old dates = %v, want %v", commits, rebased)
+	}
+}
+
+func TestGetGitLogMerge(t *testing.T) {
+	f := newMemFixture(t)
+	root, err := f.r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := f.commits(2)
+	tag := plumbing.NewHashReference(plumbing.HEAD, l[1])
+	w, err := f.r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(msg string, parents ...plumbing.Hash) plumbing.Hash {
+		t.Helper()
+		sig := &object.Signature{Name: "Tester", Email: "tester@example.com", When: f.when}
+		h, err := w.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents, AllowEmptyCommits: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.when = f.when.Add(time.Minute)
+		return h
+	}
+	// side branch forked before the tagged commit is merged after it, the
+	// commits before the tag reachable through the side branch are not new
+	side := commit("side change", root.Hash())
+	mainline := commit("main change", l[1])
+	merge := commit("merge side", mainline, side)
+	tip := plumbing.NewHashReference(plumbing.HEAD, merge)
+
+	commits, _, err := CollectGitLog(f.r, tag, tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []plumbing.Hash
+	for _, c := range commits {
+		got = append(got, c.Hash)
+	}
+	if want := []plumbing.Hash{merge, mainline, side}; !slices.Equal(got, want) {
+		t.Errorf("log of merge = %v, want %v", got, want)
+	}
+
+	// commits older than the tagged one are new if they are not its ancestors
+	tag = plumbing.NewHashReference(plumbing.HEAD, side)
+	if commits, _, err = CollectGitLog(f.r, tag, tip); err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 4 {
+		t.Errorf("log since side branch = %d commits, want merge, main change and change a, b", len(commits))
+	}
+}
+
+func TestGetGitLogUpToDate(t *testing.T) {
+	f := newMemFixture(t)
+	l := f.commits(2)
+	ref := plumbing.NewHashReference(plumbing.HEAD, l[1])
+
+	var n int
+	out, err := GetGitLog(f.r, ref, ref, &n, Theme{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || out != "" {
+		t.Errorf("log of tagged tip = %d commits %q, want none", n, out)
+	}
+}
+
+func TestGetGitLogMaxLog(t *testing.T) {
+	f := newMemFixture(t)
+	ref, err := f.r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := f.commits(3)
+	tip := plumbing.NewHashReference(plumbing.HEAD, l[2])
+
+	var n int
+	out, err := GetGitLog(f.r, ref, tip, &n, Theme{MaxLog: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every commit is counted, but only MaxLog of them are rendered
+	if n != 3 {
+		t.Errorf("counted %d commits, want 3", n)
+	}
+	if !strings.Contains(out, "change c") || strings.Contains(out, "change b") {
+		t.Errorf("log is not cut to the newest commit:\n%s", out)
+	}
+	if !strings.Contains(out, "and 2 more commits") {
+		t.Errorf("log misses note of hidden commits:\n%s", out)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	a, b := newMemFixture(t), newMemFixture(t)
+	b.when = b.when.Add(30 * time.Second) // commits of b are between the ones of a
+	log := func(f *fixture, hashes []plumbing.Hash) (l []*object.Commit) {
+		for _, h := range hashes {
+			c, err := f.r.CommitObject(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l = append([]*object.Commit{c}, l...) // newest first
+		}
+		return
+	}
+	la, lb := a.commits(2), b.commits(2)
+	feed := Feed([]Result{{Name: "a", log: log(a, la)}, {Name: "b", log: log(b, lb)}, {Name: "c"}})
+
+	want := []string{"b " + lb[1].String(), "a " + la[1].String(), "b " + lb[0].String(), "a " + la[0].String()}
+	if len(feed) != len(want) {
+		t.Fatalf("feed has %d commits, want %d", len(feed), len(want))
+	}
+	for i, c := range feed {
+		if got := c.Repo + " " + c.Hash.String(); got != want[i] {
+			t.Errorf("feed[%d] = %s, want %s", i, got, want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := RenderFeed(&buf, feed, Theme{MaxLog: 3}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], "b ") || !strings.Contains(lines[0], "change b") ||
+		!strings.Contains(lines[3], "and 1 more commits") {
+		t.Errorf("rendered feed is not cut to 3 newest commits:\n%s", buf.String())
+	}
+}
+
+func TestRenderGitLogBreaking(t *testing.T) {
+	f := newMemFixture(t)
+	var commits []*object.Commit
+	for _, msg := range []string{"fix typo", "feat!: drop Emacs 27", "Deprecate old API", "BREAKING CHANGE: new keys", "non-breaking cleanup"} {
+		c, err := f.r.CommitObject(f.commit(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, c)
+	}
+	flagged := 0
+	for _, c := range commits {
+		if DefaultBreakingPatterns.Match(c.Message) {
+			flagged++
+		}
+	}
+	if flagged != 3 {
+		t.Errorf("%d commits are flagged by default patterns, want 3", flagged)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderGitLog(&buf, commits, false, Theme{Breaking: DefaultBreakingPatterns}); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "⚠"); n != 3 || !strings.Contains(buf.String(), "fix typo") {
+		t.Errorf("log has %d flagged commits, want 3 and the rest too:\n%s", n, buf.String())
+	}
+
+	buf.Reset()
+	if err := RenderGitLog(&buf, commits, false, Theme{Breaking: DefaultBreakingPatterns, OnlyBreaking: true, MaxLog: 2}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "fix typo") || strings.Contains(out, "cleanup") || strings.Count(out, "⚠") != 2 ||
+		!strings.Contains(out, "and 1 more commits") {
+		t.Errorf("log is not limited to 2 of 3 breaking commits:\n%s", out)
+	}
+}
+
+func TestRenderGitLogGrep(t *testing.T) {
+	f := newMemFixture(t)
+	var commits []*object.Commit
+	for _, msg := range []string{"Fix typo", "Add magit-log keys", "fix crash in MAGIT-status", "Update docs"} {
+		c, err := f.r.CommitObject(f.commit(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append([]*object.Commit{c}, commits...) // newest first
+	}
+	grep, err := CompilePatterns([]string{"magit"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	highlight, err := CompilePatterns([]string{"(?-i)fix"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err = RenderGitLog(&buf, commits, false, Theme{Grep: grep, Highlight: highlight}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "typo") || strings.Contains(out, "docs") ||
+		!strings.Contains(out, "magit-log") || !strings.Contains(out, "MAGIT-status") {
+		t.Errorf("log is not filtered to commits mentioning magit:\n%s", out)
+	}
+	// (?-i) turns case folding off: "Fix typo" would match without it
+	if n := strings.Count(out, "»"); n != 1 {
+		t.Errorf("log has %d highlighted commits, want 1:\n%s", n, out)
+	}
+
+	if _, err = CompilePatterns([]string{"magit("}, true); err == nil || !strings.Contains(err.Error(), `"magit("`) {
+		t.Errorf("error of invalid pattern = %v, want one naming the pattern", err)
+	}
+}
+
+func TestRenderGitLogGroupByAuthor(t *testing.T) {
+	var commits []*object.Commit
+	for i, v := range []string{"Eve", "Jonas", "Eve", "Jonas", "Jonas"} {
+		sig := object.Signature{Name: v, Email: strings.ToLower(v) + "@example.com", When: epoch.Add(time.Duration(-i) * time.Minute)}
+		commits = append(commits, &object.Commit{
+			Hash: plumbing.NewHash(strings.Repeat(strconv.Itoa(i), 40)), Author: sig, Committer: sig,
+			Message: fmt.Sprintf("change %d by %s", i, v),
+		})
+	}
+	groups := GroupByAuthor(commits)
+	if len(groups) != 2 || len(groups[0]) != 3 || groups[0][0].Author.Name != "Jonas" || len(groups[1]) != 2 {
+		t.Fatalf("groups of 3 Jonas and 2 Eve commits are %v", groups)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderGitLog(&buf, commits, false, Theme{GroupByAuthor: true, MaxLog: 2}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	jonas, eve := strings.Index(out, "Jonas — 3 commits"), strings.Index(out, "Eve — 2 commits")
+	if jonas < 0 || eve < jonas || !strings.Contains(out[jonas:eve], "change 1 by Jonas") ||
+		!strings.Contains(out[jonas:eve], "and 1 more commits") || !strings.Contains(out[eve:], "change 2 by Eve") {
+		t.Errorf("log is not grouped by author, most active first:\n%s", out)
+	}
+
+	// authors having no commits passing the filters are not listed
+	buf.Reset()
+	grep, _ := CompilePatterns([]string{"eve"}, true)
+	if err := RenderGitLog(&buf, commits, false, Theme{GroupByAuthor: true, Grep: grep}); err != nil {
+		t.Fatal(err)
+	}
+	if out = buf.String(); strings.Contains(out, "Jonas") || !strings.Contains(out, "Eve — 2 commits") {
+		t.Errorf("log lists authors without matching commits:\n%s", out)
+	}
+}
+
+func TestCommitURL(t *testing.T) {
+	const h = "0123abcd"
+	for remote, want := range map[string]string{
+		"https://github.com/magit/magit.git":         "https://github.com/magit/magit/commit/" + h,
+		"https://***@github.com/magit/magit":         "https://github.com/magit/magit/commit/" + h,
+		"git@github.com:magit/magit.git":             "https://github.com/magit/magit/commit/" + h,
+		"ssh://git@gitlab.com/ideasman42/emacs-undo": "https://gitlab.com/ideasman42/emacs-undo/-/commit/" + h,
+		"https://gitlab.gnome.org/GNOME/foo.git/":    "https://gitlab.gnome.org/GNOME/foo/-/commit/" + h,
+		"https://git.sr.ht/~ashton314/emacs-bedrock": "https://git.sr.ht/~ashton314/emacs-bedrock/commit/" + h,
+		"https://codeberg.org/akib/emacs-eat":        "https://codeberg.org/akib/emacs-eat/commit/" + h,
+		"https://git.savannah.gnu.org/git/emacs.git": "",
+		"/home/user/src/pkg":                         "",
+		"https://github.com/magit":                   "",
+	} {
+		if got := CommitURL(remote, h); got != want {
+			t.Errorf("CommitURL(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestCompareURL(t *testing.T) {
+	for remote, want := range map[string]string{
+		"git@github.com:magit/magit.git":           "https://github.com/magit/magit/compare/aaa...bbb",
+		"https://gitlab.com/ideasman42/emacs-undo": "https://gitlab.com/ideasman42/emacs-undo/-/compare/aaa...bbb",
+		"https://codeberg.org/akib/emacs-eat.git":  "https://codeberg.org/akib/emacs-eat/compare/aaa...bbb",
+		"https://git.sr.ht/~ashton314/bedrock":     "",
+		"https://git.savannah.gnu.org/git/emacs":   "",
+	} {
+		if got := CompareURL(remote, "aaa", "bbb"); got != want {
+			t.Errorf("CompareURL(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestRenderGitLogHyperlinks(t *testing.T) {
+	f := newMemFixture(t)
+	c, err := f.r.CommitObject(f.commit("Add magit-log keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := "\x1b]8;;https://github.com/magit/magit/commit/" + c.Hash.String()
+
+	var buf bytes.Buffer
+	th := Theme{Hyperlinks: true, remote: "git@github.com:magit/magit.git"}
+	if err = RenderGitLog(&buf, []*object.Commit{c}, false, th); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), link) {
+		t.Errorf("log has no link of commit to GitHub:\n%q", buf.String())
+	}
+	buf.Reset()
+	th.Hyperlinks = false
+	if err = RenderGitLog(&buf, []*object.Commit{c}, false, th); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "\x1b]8;;") {
+		t.Errorf("log with hyperlinks disabled has links:\n%q", buf.String())
+	}
+}
@@ -0,0 +1,101 @@
+package straightup
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Colors of rendered output by element: ANSI 256 color numbers or #rrggbb
+// hex ones, empty for the default color of terminal
+type Palette struct {
+	Date      string // of commits
+	Hash      string
+	Author    string
+	Message   string
+	Header    string // of repo headers like Fetched from
+	Path      string // of local paths and URLs
+	Stdout    string // of output of external commands, e.g. restart ones
+	Stderr    string
+	Count     string // of commit counts, e.g. 5 new commits
+	Updated   string // of updated repos and the ones behind upstream
+	Warning   string // of advice to act, e.g. rebuild or restart Emacs
+	Breaking  string // of breaking changes
+	Highlight string // of commits matching --highlight
+	Release   string // of new release tags
+	Version   string // of package version changes
+	Insert    string // of diffstat insertions
+	Delete    string // of diffstat deletions
+	Error     string // of failures
+	Notice    string // of notes on repos, e.g. switched branches
+	Feed      string // of repo names in feed, space-separated colors picked by repo
+}
+
+// Palette of dark terminal backgrounds, the default one
+var DefaultPalette = Palette{
+	Date: "140", Hash: "104", Author: "111", Message: "108",
+	Header: "3", Path: "110", Stdout: "147", Stderr: "175",
+	Count: "208", Updated: "108", Warning: "208", Breaking: "196", Highlight: "220",
+	Release: "214", Version: "114", Insert: "108", Delete: "167",
+	Error: "1", Notice: "3", Feed: "75 114 179 176 80 209 150 111 215 146",
+}
+
+// Built-in palettes by name: monochrome one leaves everything in the default
+// color
+var Palettes = map[string]Palette{
+	"dark": DefaultPalette,
+	"light": {
+		Date: "90", Hash: "25", Author: "24", Message: "238",
+		Header: "130", Path: "31", Stdout: "60", Stderr: "125",
+		Count: "166", Updated: "28", Warning: "166", Breaking: "160", Highlight: "136",
+		Release: "130", Version: "29", Insert: "28", Delete: "124",
+		Error: "160", Notice: "136", Feed: "25 28 130 90 30 166 64 61 136 96",
+	},
+	"mono": {},
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Override colors of palette by the non-empty ones of o
+func (p Palette) With(o Palette) Palette {
+	pv, ov := reflect.ValueOf(&p).Elem(), reflect.ValueOf(o)
+	for i := range pv.NumField() {
+		if s := ov.Field(i).String(); s != "" {
+			pv.Field(i).SetString(s)
+		}
+	}
+	return p
+}
+
+// Check colors of palette, the first bad one is reported
+func (p Palette) Check() error {
+	v := reflect.ValueOf(p)
+	for i := range v.NumField() {
+		colors := []string{v.Field(i).String()}
+		if v.Type().Field(i).Name == "Feed" {
+			colors = strings.Fields(colors[0])
+		}
+		for _, s := range colors {
+			if n, err := strconv.Atoi(s); s == "" || hexColor.MatchString(s) || err == nil && n >= 0 && n < 256 {
+				continue
+			}
+			return fmt.Errorf("bad %s color %q, use 0-255 or #rrggbb", strings.ToLower(v.Type().Field(i).Name), s)
+		}
+	}
+	return nil
+}
+
+// Color of repo name in feed, one of Feed colors by hash of the name, empty
+// if there are none
+func (p Palette) FeedColor(repo string) string {
+	colors := strings.Fields(p.Feed)
+	if len(colors) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(repo))
+	return colors[h.Sum32()%uint32(len(colors))]
+}
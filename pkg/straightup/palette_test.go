@@ -0,0 +1,44 @@
+package straightup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPalette(t *testing.T) {
+	p := Palettes["light"].With(Palette{Hash: "#d787af"})
+	if p.Hash != "#d787af" || p.Date != Palettes["light"].Date {
+		t.Errorf("light palette with hash override = %+v", p)
+	}
+	for _, v := range []Palette{DefaultPalette, Palettes["light"], Palettes["mono"], p} {
+		if err := v.Check(); err != nil {
+			t.Errorf("check of %+v: %v", v, err)
+		}
+	}
+	for _, name := range []string{"dark", "light"} {
+		v := reflect.ValueOf(Palettes[name])
+		for i := range v.NumField() {
+			if v.Field(i).String() == "" {
+				t.Errorf("%s palette has no %s color", name, v.Type().Field(i).Name)
+			}
+		}
+	}
+	if Palettes["mono"] != (Palette{}) {
+		t.Errorf("mono palette has colors: %+v", Palettes["mono"])
+	}
+	if c := DefaultPalette.FeedColor("magit"); c == "" || c != DefaultPalette.FeedColor("magit") || !strings.Contains(" "+DefaultPalette.Feed+" ", " "+c+" ") {
+		t.Errorf("feed color of magit = %q, want the same one of %q every time", c, DefaultPalette.Feed)
+	}
+	if c := Palettes["mono"].FeedColor("magit"); c != "" {
+		t.Errorf("mono feed color = %q, want none", c)
+	}
+	if err := (Palette{Feed: "75 #d787af 300"}).Check(); err == nil {
+		t.Error("bad feed color 300 passes the check")
+	}
+	for _, c := range []string{"256", "-1", "#d787a", "pink"} {
+		if err := (Palette{Author: c}).Check(); err == nil {
+			t.Errorf("bad color %q passes the check", c)
+		}
+	}
+}
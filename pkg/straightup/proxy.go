@@ -0,0 +1,49 @@
+package straightup
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/net/http/httpproxy"
+)
+
+var ErrSSHProxy = errors.New("proxy not supported for ssh remotes, only socks5:// proxy is")
+
+// Proxy of remote: proxy URL if it's set, HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables for http(s) remotes otherwise; ssh remotes can be
+// reached only via socks5 proxy URL
+func RemoteProxy(rr *git.Remote, proxy string) (opts transport.ProxyOptions, err error) {
+	ep, err := transport.NewEndpoint(rr.Config().URLs[0])
+	if err != nil {
+		return opts, ScrubError(err)
+	}
+	switch ep.Protocol {
+	case "http", "https":
+		if proxy != "" {
+			opts.URL = proxy
+			break
+		}
+		u, err := httpproxy.FromEnvironment().ProxyFunc()(&url.URL{Scheme: ep.Protocol, Host: ep.Host})
+		if err != nil {
+			return opts, ScrubError(err)
+		}
+		if u != nil {
+			opts.URL = u.String()
+		}
+	case "ssh":
+		if proxy == "" {
+			break
+		}
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return opts, ScrubError(err)
+		}
+		if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+			return opts, ErrSSHProxy
+		}
+		opts.URL = proxy
+	}
+	return opts, nil
+}
@@ -0,0 +1,82 @@
+package straightup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Packages built by straight.el from every repo of reposDir: files of
+// packages in build directory next to it are symlinks to files of repos, so a
+// repo may provide several packages, e.g. magit provides magit-section too
+func BuildPackages(reposDir string) (map[string][]string, error) {
+	repos, err := filepath.Abs(reposDir)
+	if err != nil {
+		return nil, err
+	}
+	builds, err := os.ReadDir(filepath.Join(filepath.Dir(repos), "build"))
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]string)
+	for _, b := range builds {
+		if !b.IsDir() {
+			continue
+		}
+		dir := filepath.Join(filepath.Dir(repos), "build", b.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.Type()&os.ModeSymlink == 0 {
+				continue
+			}
+			target, err := os.Readlink(filepath.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(dir, target)
+			}
+			rel, err := filepath.Rel(repos, target)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			repo := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+			m[repo] = append(m[repo], b.Name())
+			break
+		}
+	}
+	for _, v := range m {
+		slices.Sort(v)
+	}
+	return m, nil
+}
+
+// Packages of repos by packages of builds, repo missing in builds is taken as
+// package of the same name without .el suffix
+func RepoPackages(repos []string, builds map[string][]string) []string {
+	var l []string
+	for _, v := range repos {
+		if pkgs, ok := builds[v]; ok {
+			l = append(l, pkgs...)
+		} else {
+			l = append(l, strings.TrimSuffix(v, ".el"))
+		}
+	}
+	slices.Sort(l)
+	return slices.Compact(l)
+}
+
+// Elisp form rebuilding packages by straight.el in running Emacs
+func RebuildForm(pkgs []string) string {
+	var b strings.Builder
+	b.WriteString("(progn")
+	for _, v := range pkgs {
+		b.WriteString(" (straight-rebuild-package " + elispString(v) + ")")
+	}
+	b.WriteString(")")
+	return b.String()
+}
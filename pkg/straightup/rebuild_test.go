@@ -0,0 +1,52 @@
+package straightup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildPackages(t *testing.T) {
+	straight := t.TempDir()
+	repos := filepath.Join(straight, "repos")
+	for _, v := range []struct{ pkg, target string }{
+		{"magit", "repos/magit/lisp/magit.el"},
+		{"magit-section", "repos/magit/lisp/magit-section.el"},
+		{"dash", "repos/dash.el/dash.el"},
+		{"other", "elsewhere/other.el"},
+	} {
+		dir := filepath.Join(straight, "build", v.pkg)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		// compiled files are not symlinks
+		if err := os.WriteFile(filepath.Join(dir, "a.elc"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(straight, v.target), filepath.Join(dir, filepath.Base(v.target))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	builds, err := BuildPackages(repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"magit": {"magit", "magit-section"}, "dash.el": {"dash"}}
+	if !reflect.DeepEqual(builds, want) {
+		t.Errorf("packages of repos = %v, want %v", builds, want)
+	}
+
+	pkgs := RepoPackages([]string{"magit", "dash.el", "s.el"}, builds)
+	if want := []string{"dash", "magit", "magit-section", "s"}; !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("packages = %v, want %v", pkgs, want)
+	}
+}
+
+func TestRebuildForm(t *testing.T) {
+	got := RebuildForm([]string{"magit", `we"ird\`})
+	want := `(progn (straight-rebuild-package "magit") (straight-rebuild-package "we\"ird\\"))`
+	if got != want {
+		t.Errorf("form = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,282 @@
+package straightup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Package managers with known repos directories
+const (
+	ManagerAuto     = "auto"
+	ManagerStraight = "straight"
+	ManagerElpaca   = "elpaca"
+	ManagerDoom     = "doom" // straight repos of Doom Emacs
+)
+
+const DefaultReposDir = "~/.emacs.d/straight/repos"
+
+// Repos directories of package managers in Emacs config directory, Doom goes
+// first as it's detected by its CLI
+var managerRepos = []struct {
+	name  string
+	repos func(dir string) string
+}{
+	{ManagerDoom, DoomReposDir},
+	{ManagerStraight, func(dir string) string { return filepath.Join(dir, "straight", "repos") }},
+	{ManagerElpaca, func(dir string) string { return filepath.Join(dir, "elpaca", "repos") }},
+}
+
+// Emacs config directories in the order Emacs picks user-emacs-directory:
+// ~/.emacs.d wins if it or ~/.emacs exists, $XDG_CONFIG_HOME/emacs (default
+// ~/.config/emacs) comes first otherwise; on Windows they are looked up in
+// every home directory Emacs may use, see EmacsHomes
+func EmacsDirs() ([]string, error) {
+	return emacsDirs(runtime.GOOS)
+}
+
+func emacsDirs(goos string) ([]string, error) {
+	homes, err := EmacsHomes(goos)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, home := range homes {
+		for _, d := range homeEmacsDirs(home) {
+			if !slices.Contains(dirs, d) {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// Home directories of Emacs on goos: the user home one, on Windows Emacs
+// takes $HOME if it's set and %APPDATA% otherwise, so ~/AppData/Roaming and
+// the user profile directory are tried after them
+func EmacsHomes(goos string) ([]string, error) {
+	home, err := os.UserHomeDir()
+	if goos != "windows" {
+		if err != nil {
+			return nil, err
+		}
+		return []string{home}, nil
+	}
+	var homes []string
+	add := func(p string) {
+		if p != "" && !slices.Contains(homes, p) {
+			homes = append(homes, p)
+		}
+	}
+	add(os.Getenv("HOME"))
+	add(os.Getenv("APPDATA"))
+	if err == nil {
+		add(filepath.Join(home, "AppData", "Roaming"))
+		add(home)
+	}
+	if len(homes) == 0 {
+		return nil, errors.New("home directory is unknown: neither HOME nor APPDATA is set")
+	}
+	return homes, nil
+}
+
+// Emacs config directories of home directory in the order of EmacsDirs
+func homeEmacsDirs(home string) []string {
+	legacy := filepath.Join(home, ".emacs.d")
+	var xdg []string
+	if d := os.Getenv("XDG_CONFIG_HOME"); d != "" {
+		xdg = append(xdg, filepath.Join(d, "emacs"))
+	}
+	if d := filepath.Join(home, ".config", "emacs"); len(xdg) == 0 || xdg[0] != d {
+		xdg = append(xdg, d)
+	}
+	for _, p := range []string{legacy, filepath.Join(home, ".emacs")} {
+		if _, err := os.Stat(p); err == nil {
+			return append([]string{legacy}, xdg...)
+		}
+	}
+	return append(xdg, legacy)
+}
+
+// Repos directory of package manager in Emacs config directories given by
+// EmacsDirs, see ManagerReposDirIn
+func ManagerReposDir(manager string) (name, dir string, other []string, err error) {
+	dirs, err := EmacsDirs()
+	if err != nil {
+		return "", "", nil, err
+	}
+	return ManagerReposDirIn(manager, dirs)
+}
+
+// Repos directory of package manager: the first existing one of Emacs config
+// directories dirs, auto takes Doom (of directory having bin/doom), straight
+// and elpaca ones in this order; the name of picked manager and other
+// existing repos directories are returned too, if there is none the one of
+// dirs[0] is picked, straight for auto
+func ManagerReposDirIn(manager string, dirs []string) (name, dir string, other []string, err error) {
+	candidates := managerRepos
+	switch manager {
+	case ManagerDoom:
+		candidates = managerRepos[:1]
+	case ManagerStraight:
+		candidates = managerRepos[1:2]
+	case ManagerElpaca:
+		candidates = managerRepos[2:]
+	case ManagerAuto, "":
+	default:
+		return "", "", nil, fmt.Errorf("unknown package manager %q, use straight, elpaca, doom or auto", manager)
+	}
+	for _, d := range dirs {
+		for _, v := range candidates {
+			if v.name == ManagerDoom && len(candidates) > 1 && DoomBin(d) == "" {
+				continue
+			}
+			p := v.repos(d)
+			if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+				continue
+			}
+			if dir == "" {
+				name, dir = v.name, p
+			} else {
+				other = append(other, p)
+			}
+		}
+	}
+	if dir == "" {
+		fallback := candidates[0]
+		if len(candidates) > 1 {
+			fallback = managerRepos[1]
+		}
+		name, dir = fallback.name, fallback.repos(dirs[0])
+	}
+	return name, dir, other, nil
+}
+
+// Expand leading ~ of path to the user home directory
+func ExpandHome(p string) (string, error) {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, p[1:]), nil
+}
+
+// List git repos of dir, if dir is empty the default straight repos
+// directory is used; other entries of dir are returned too
+func DiscoverRepos(dir string) (repos, other []string, err error) {
+	if dir == "" {
+		dir = DefaultReposDir
+	}
+	if dir, err = ExpandHome(dir); err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot use repos directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, nil, fmt.Errorf("cannot use repos directory: %s is not a directory", dir)
+	}
+
+	if repos, other, err = ScanReposDir(dir); err != nil {
+		return nil, nil, err
+	}
+	if len(repos) == 0 {
+		return nil, other, fmt.Errorf("no git repositories found in %s", dir)
+	}
+	return repos, other, nil
+}
+
+// Split entries of dir to git repos, i.e. directories with .git directory or
+// file (worktrees, submodules), and the rest: stray files, partial clones
+func ScanReposDir(dir string) (repos, other []string, err error) {
+	entries, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range entries {
+		if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+			other = append(other, p)
+		} else if _, err = os.Lstat(filepath.Join(p, ".git")); err != nil {
+			other = append(other, p)
+		} else {
+			repos = append(repos, p)
+		}
+	}
+	return
+}
+
+// Keep only repos with given directory names, return also names which
+// do not match any repo
+func FilterReposByName(repos, names []string) (filtered, unknown []string) {
+	want := make(map[string]bool, len(names))
+	for _, v := range names {
+		want[v] = false
+	}
+	for _, p := range repos {
+		if _, ok := want[filepath.Base(p)]; ok {
+			want[filepath.Base(p)] = true
+			filtered = append(filtered, p)
+		}
+	}
+	for _, v := range names {
+		if !want[v] {
+			unknown = append(unknown, v)
+		}
+	}
+	return
+}
+
+// Read exclude list: one repo name or glob per line, empty lines and
+// lines started with # are ignored, missing file means empty list
+func ReadExcludeFile(p string) (patterns []string, err error) {
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		patterns = append(patterns, l)
+	}
+	return patterns, sc.Err()
+}
+
+// Drop repos which directory names match any of patterns, return
+// the number of dropped repos
+func ExcludeRepos(repos, patterns []string) (kept []string, n int, err error) {
+	for _, p := range repos {
+		excluded := false
+		for _, v := range patterns {
+			if excluded, err = filepath.Match(v, filepath.Base(p)); err != nil {
+				return nil, 0, fmt.Errorf("bad exclude pattern %q: %w", v, err)
+			}
+			if excluded {
+				break
+			}
+		}
+		if excluded {
+			n++
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package straightup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManagerReposDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	mkdir := func(p string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(home, p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(manager, wantName, wantDir string, wantOther ...string) {
+		t.Helper()
+		name, dir, other, err := ManagerReposDir(manager)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range wantOther {
+			wantOther[i] = filepath.Join(home, wantOther[i])
+		}
+		if name != wantName || dir != filepath.Join(home, wantDir) || !reflect.DeepEqual(other, wantOther) {
+			t.Errorf("repos of %s = %s %s %v, want %s %s %v", manager, name, dir, other, wantName, wantDir, wantOther)
+		}
+	}
+
+	// nothing exists yet: XDG directory is the one Emacs would create
+	check(ManagerAuto, ManagerStraight, ".config/emacs/straight/repos")
+	mkdir(".config/emacs/elpaca/repos")
+	check(ManagerAuto, ManagerElpaca, ".config/emacs/elpaca/repos")
+	check(ManagerStraight, ManagerStraight, ".config/emacs/straight/repos")
+	mkdir(".config/emacs/straight/repos")
+	check(ManagerAuto, ManagerStraight, ".config/emacs/straight/repos", ".config/emacs/elpaca/repos")
+
+	// existing ~/.emacs.d is loaded instead of XDG directory
+	mkdir(".emacs.d/straight/repos")
+	check(ManagerStraight, ManagerStraight, ".emacs.d/straight/repos", ".config/emacs/straight/repos")
+	check(ManagerElpaca, ManagerElpaca, ".config/emacs/elpaca/repos")
+
+	if _, _, _, err := ManagerReposDir("package.el"); err == nil {
+		t.Error("unknown manager is accepted")
+	}
+}
+
+func TestEmacsDirs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+
+	want := []string{filepath.Join(home, "xdg/emacs"), filepath.Join(home, ".config/emacs"), filepath.Join(home, ".emacs.d")}
+	if l, err := EmacsDirs(); err != nil || !reflect.DeepEqual(l, want) {
+		t.Errorf("dirs = %v, %v, want %v", l, err, want)
+	}
+	// ~/.emacs makes Emacs stick to ~/.emacs.d
+	if err := os.WriteFile(filepath.Join(home, ".emacs"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want[2:], want[:2]...)
+	if l, err := EmacsDirs(); err != nil || !reflect.DeepEqual(l, want) {
+		t.Errorf("dirs with ~/.emacs = %v, %v, want %v", l, err, want)
+	}
+}
+
+func TestEmacsDirsWindows(t *testing.T) {
+	root := t.TempDir()
+	appdata := filepath.Join(root, "AppData", "Roaming")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("APPDATA", appdata)
+	if err := os.MkdirAll(filepath.Join(appdata, ".emacs.d/straight/repos"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// without HOME Emacs lives in %APPDATA%
+	t.Setenv("HOME", "")
+	want := []string{filepath.Join(appdata, ".emacs.d"), filepath.Join(appdata, ".config/emacs")}
+	if l, err := emacsDirs("windows"); err != nil || !reflect.DeepEqual(l, want) {
+		t.Errorf("dirs = %v, %v, want %v", l, err, want)
+	}
+	// HOME set by the user goes first
+	home := filepath.Join(root, "home")
+	t.Setenv("HOME", home)
+	want = append([]string{filepath.Join(home, ".config/emacs"), filepath.Join(home, ".emacs.d")}, want...)
+	if l, err := emacsDirs("windows"); err != nil || !reflect.DeepEqual(l[:4], want) {
+		t.Errorf("dirs with HOME = %v, %v, want %v first", l, err, want)
+	}
+	if _, dir, _, err := ManagerReposDirIn(ManagerStraight, want); err != nil || dir != filepath.Join(appdata, ".emacs.d/straight/repos") {
+		t.Errorf("repos = %s, %v, want the one of %%APPDATA%%", dir, err)
+	}
+
+	t.Setenv("HOME", "")
+	t.Setenv("APPDATA", "")
+	if _, err := emacsDirs("windows"); err == nil {
+		t.Error("no home directory is accepted")
+	}
+}
+
+func TestDoomReposDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("DOOMLOCALDIR", "")
+	doom := filepath.Join(home, ".config/emacs")
+	for _, p := range []string{"bin", ".local/straight/repos/build-28.2/old/.git", ".local/straight/repos/build-29.1/magit/.git"} {
+		if err := os.MkdirAll(filepath.Join(doom, p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(doom, "bin/doom"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// the versioned subdirectory modified last is picked
+	old := filepath.Join(doom, ".local/straight/repos/build-28.2")
+	if err := os.Chtimes(old, epoch, epoch); err != nil {
+		t.Fatal(err)
+	}
+	name, dir, _, err := ManagerReposDir(ManagerAuto)
+	if err != nil || name != ManagerDoom || dir != filepath.Join(doom, ".local/straight/repos/build-29.1") {
+		t.Errorf("repos = %s %s, %v, want doom build-29.1", name, dir, err)
+	}
+	if p := DoomBin(doom); p != filepath.Join(doom, "bin/doom") {
+		t.Errorf("doom CLI = %q", p)
+	}
+
+	local := filepath.Join(home, "doom-local")
+	if err := os.MkdirAll(filepath.Join(local, "straight/repos/evil/.git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOOMLOCALDIR", local)
+	if dir := DoomReposDir(doom); dir != filepath.Join(local, "straight/repos") {
+		t.Errorf("repos of $DOOMLOCALDIR = %s", dir)
+	}
+}
@@ -0,0 +1,73 @@
+package straightup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// Report whether err looks like a transient network failure worth to retry,
+// auth failures, merge conflicts and timeouts of the whole repo are not
+func IsTransientError(err error) bool {
+	var (
+		netErr  net.Error
+		httpErr *githttp.Err
+		unexp   *plumbing.UnexpectedError
+	)
+	if errors.As(err, &unexp) { // go-git does not unwrap it
+		err = unexp.Err
+	}
+	switch {
+	case err == nil, errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &httpErr):
+		code := httpErr.StatusCode()
+		return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, syscall.ETIMEDOUT):
+		return true
+	case errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// Delay before retry attempt (starting from 0): exponential backoff from
+// 1 second with jitter of ±50%
+func Backoff(attempt int) time.Duration {
+	d := time.Second << min(attempt, 6)
+	return d/2 + rand.N(d)
+}
+
+// Call network operation f of remote url until it succeeds, fails with non
+// transient error or retries are exhausted, retries are logged to log
+func Retry(ctx context.Context, url string, retries int, log io.Writer, f func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if attempt >= retries || !IsTransientError(err) {
+			return ScrubError(err)
+		}
+		d := Backoff(attempt)
+		if log != nil {
+			fmt.Fprintf(log, "%s: %s, retry %d/%d in %s\n", Scrub(url), Scrub(err.Error()), attempt+1, retries, d.Round(time.Millisecond))
+		}
+		select {
+		case <-ctx.Done():
+			return ScrubError(err)
+		case <-time.After(d):
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package straightup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Snapshot of local changes made by --autostash, go-git has no stash support
+const StashRef = plumbing.ReferenceName("refs/updstraight/stash")
+
+// Snapshot uncommitted changes of tracked files into a commit on top of
+// head saved to StashRef, then reset the worktree to head. Return nil if
+// the worktree is clean.
+func StashChanges(r *git.Repository, head *plumbing.Reference) (*object.Commit, error) {
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	files, err := DirtyFiles(w)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	for _, f := range files {
+		if _, err = w.Add(f); err != nil {
+			return nil, err
+		}
+	}
+
+	// the commit moves the branch, it's reset back below
+	sig := &object.Signature{Name: "updstraight", Email: "updstraight@localhost", When: time.Now()}
+	h, err := w.Commit("updstraight autostash", &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		return nil, err
+	}
+	if err = r.Storer.SetReference(plumbing.NewHashReference(StashRef, h)); err != nil {
+		return nil, err
+	}
+	if err = w.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		return nil, err
+	}
+	return r.CommitObject(h)
+}
+
+// Reapply changes of stash snapshot to the worktree. Files changed by the
+// update too are not touched and returned as conflicts, in this case the
+// snapshot ref is kept, so the changes can be recovered manually.
+func UnstashChanges(r *git.Repository, stash *object.Commit) (conflicts []string, err error) {
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	base, err := stash.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := changedFiles(base, stash)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := changedFiles(base, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	for f := range local {
+		if upstream[f] {
+			conflicts = append(conflicts, f)
+			continue
+		}
+		if err = restoreFile(w, stash, f); err != nil {
+			return conflicts, fmt.Errorf("restore %s: %w", f, err)
+		}
+	}
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+	return nil, r.Storer.RemoveReference(StashRef)
+}
+
+// Set of files changed between two commits
+func changedFiles(from, to *object.Commit) (map[string]bool, error) {
+	a, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+	b, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(a, b)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(changes))
+	for _, c := range changes {
+		if c.From.Name != "" {
+			files[c.From.Name] = true
+		}
+		if c.To.Name != "" {
+			files[c.To.Name] = true
+		}
+	}
+	return files, nil
+}
+
+// Write file f of commit c to the worktree, remove it if c does not have it
+func restoreFile(w *git.Worktree, c *object.Commit, f string) error {
+	file, err := c.File(f)
+	if err == object.ErrFileNotFound {
+		err = w.Filesystem.Remove(f)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	mode, err := file.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	src, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := w.Filesystem.OpenFile(f, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
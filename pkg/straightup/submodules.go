@@ -0,0 +1,42 @@
+package straightup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// Init and update submodules of repo recursively, fetching depth recent
+// commits (0 means full history), return the number of submodules which were
+// not at the commit recorded by the superproject
+func UpdateSubmodules(ctx context.Context, r *git.Repository, depth int) (n int, err error) {
+	w, err := r.Worktree()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = os.Stat(filepath.Join(w.Filesystem.Root(), ".gitmodules")); errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	subs, err := w.Submodules()
+	if err != nil {
+		return 0, err
+	}
+	for _, sub := range subs {
+		st, err := sub.Status()
+		if err != nil || !st.IsClean() {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	err = subs.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Depth:             depth,
+	})
+	return n, ScrubError(err)
+}
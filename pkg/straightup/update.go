@@ -0,0 +1,850 @@
+package straightup
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Settings of repo update
+type Options struct {
+	Network
+
+	DryRun         bool          // fetch and collect pending commits, nothing is merged and the marker stays
+	Branch         string        // branch to check out and update, empty means the checked out one
+	Pinned         string        // commit of version lockfile, the repo is not updated if set
+	PinnedBy       string        // version lockfile pinning the repo, for messages
+	Autostash      bool          // snapshot local changes before pull and reapply them afterwards
+	Merge          bool          // merge upstream into diverged branch with git instead of skipping it
+	Rebase         bool          // rebase diverged branch onto upstream with git, wins over Merge
+	Force          bool          // hard-reset to upstream, local commits and changes are discarded
+	UpdateDetached bool          // fetch repo in detached HEAD to count how far behind it is
+	MigrateTags    bool          // move legacy Updated.At tag to update marker, see MigrateGitTag
+	SwitchDefault  bool          // check out the renamed default branch of remote, e.g. main instead of master
+	MinAge         time.Duration // skip repos updated more recently, 0 means never skip
+	Stat           bool          // compute files changed by the update
+	Breaking       Patterns      // flag new commits which look like breaking changes
+	Timeout        time.Duration // of network operations, 0 means no timeout
+	Warnings       io.Writer     // warnings like skipped dirty worktree, nil if discarded
+	Fetched        *Fetched      // remote is fetched by FetchRepo already, merge without network access
+
+	// Asked before the pull, the update is skipped if it returns false
+	Confirm func(ctx context.Context, p string, r *git.Repository, rr *git.Remote, head *plumbing.Reference) (bool, error)
+	// Called before the pull if fetched upstream has new commits, the update
+	// fails if it returns error
+	BeforeMerge func(p string, head, upstream plumbing.Hash, commits int) error
+}
+
+// Log step of repo p update and time spent on it
+func (o Options) debug(p string, start time.Time, s ...any) {
+	if o.Log != nil {
+		fmt.Fprintf(o.Log, "%s: %s (%s)\n", filepath.Base(p), Scrub(fmt.Sprint(s...)), time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func (o Options) warn(s ...string) {
+	if o.Warnings != nil {
+		fmt.Fprintln(o.Warnings, strings.Join(s, " "))
+	}
+}
+
+// Context of repo network operations, limited by Timeout
+func (o Options) networkContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout > 0 {
+		return context.WithTimeout(ctx, o.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Result of repo update, rendered by RenderResult and used by JSON output
+type Result struct {
+	Name         string                   `json:"name"`
+	Path         string                   `json:"path"`
+	RemoteURL    string                   `json:"remote_url,omitempty"`
+	PreviousHash string                   `json:"previous_hash,omitempty"`
+	NewHash      string                   `json:"new_hash,omitempty"`
+	CompareURL   string                   `json:"compare_url,omitempty"` // forge page of the update, e.g. GitHub compare
+	NewCommits   int                      `json:"new_commits"`
+	Commits      []CommitInfo             `json:"commits"`
+	Breaking     int                      `json:"breaking_commits,omitempty"` // new commits matching Options.Breaking
+	Truncated    bool                     `json:"truncated,omitempty"`        // history is cut by shallow fetch
+	NewReleases  []string                 `json:"new_releases,omitempty"`
+	Versions     []VersionBump            `json:"version_bumps,omitempty"` // changed Version headers of .el files
+	Status       string                   `json:"status"`
+	Attention    string                   `json:"attention,omitempty"` // why the repo needs manual intervention
+	DirtyFiles   []string                 `json:"dirty_files,omitempty"`
+	Behind       int                      `json:"behind,omitempty"`    // commits HEAD is behind upstream, of detached or diverged repo
+	Pinned       string                   `json:"pinned,omitempty"`    // commit of version lockfile
+	PinnedBy     string                   `json:"pinned_by,omitempty"` // version lockfile
+	Drifted      bool                     `json:"drifted,omitempty"`   // HEAD is not at the commit of version lockfile
+	Ahead        int                      `json:"ahead,omitempty"`     // commits of diverged local branch missing upstream
+	Discarded    []string                 `json:"discarded,omitempty"` // local commits dropped by Force
+	Rewritten    []CommitInfo             `json:"rewritten,omitempty"` // recent commits of rewritten upstream missing locally
+	Default      string                   `json:"default,omitempty"`   // renamed default branch of remote
+	Switched     bool                     `json:"switched,omitempty"`  // Default is checked out by SwitchDefault
+	Initial      bool                     `json:"initial,omitempty"`   // the commits are the whole history of repo empty before
+	LastUpdate   time.Time                `json:"updated_at,omitzero"` // of fresh repo, by update marker
+	Submodules   int                      `json:"submodules_updated,omitempty"`
+	Partial      string                   `json:"-"` // error of a follow-up step after successful pull
+	Stat         *DiffStat                `json:"stat,omitempty"`
+	Duration     time.Duration            `json:"duration_ns"`
+	Phases       map[string]time.Duration `json:"phases_ns,omitempty"` // durations of update phases
+	Downloaded   int64                    `json:"downloaded_bytes"`    // size of packfiles fetched by the update
+	Error        string                   `json:"error,omitempty"`
+
+	log      []*object.Commit // new commits, newest first
+	rewrites []*object.Commit // recent commits of rewritten upstream, newest first
+	upstream string           // remote branch HEAD is compared with, e.g. origin/master
+	shown    bool             // the commits were shown already by interactive prompt
+}
+
+// Phases of repo update, pull is merge: go-git fetches and merges in one step
+const (
+	PhaseOpen  = "open"
+	PhaseFetch = "fetch"
+	PhaseMerge = "merge"
+	PhaseLog   = "log"
+)
+
+// Add time since start to the duration of update phase
+func (rep *Result) Track(phase string, start time.Time) {
+	if rep.Phases == nil {
+		rep.Phases = make(map[string]time.Duration)
+	}
+	rep.Phases[phase] += time.Since(start)
+}
+
+// Statuses of repo update
+const (
+	StatusUpdated     = "updated"
+	StatusPending     = "pending" // has new commits, but nothing is merged (dry run)
+	StatusUpToDate    = "up-to-date"
+	StatusSkipped     = "skipped"
+	StatusAttention   = "needs-attention" // the repo needs manual intervention
+	StatusDirty       = "dirty"           // skipped due to uncommitted changes
+	StatusDetached    = "detached"        // pinned commit, skipped
+	StatusPinned      = "pinned"          // frozen by version lockfile, skipped
+	StatusLocal       = "local"           // no remotes, skipped
+	StatusFresh       = "fresh"           // updated within Options.MinAge, skipped
+	StatusEmpty       = "empty"           // no commits, neither locally nor upstream
+	StatusDiverged    = "diverged"        // local branch has own commits, nothing merged
+	StatusRewritten   = "rewritten"       // upstream history is rewritten since the last update, nothing merged
+	StatusForced      = "force-reset"     // hard-reset to upstream, local commits discarded
+	StatusPartial     = "partial"         // pulled, but a follow-up step like submodules update failed
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted" // context of the run is canceled, e.g. by SIGINT
+)
+
+// Outcomes of repo update, classes of statuses counted by the wrap-up of run
+const (
+	OutcomeUpdated   = "updated" // new commits are merged, or are pending with dry run
+	OutcomeUnchanged = "unchanged"
+	OutcomeSkipped   = "skipped" // not updated on purpose or due to the state of repo
+	OutcomeFailed    = "failed"  // partial updates too
+)
+
+// Outcome class of repo update by its status
+func (rep Result) Outcome() string {
+	switch rep.Status {
+	case StatusUpdated, StatusPending, StatusForced:
+		return OutcomeUpdated
+	case StatusUpToDate:
+		return OutcomeUnchanged
+	case StatusFailed, StatusPartial:
+		return OutcomeFailed
+	}
+	return OutcomeSkipped
+}
+
+// Update repo p and return result of the update, errors are saved to the
+// result too
+func UpdateRepo(ctx context.Context, p string, o Options) (Result, error) {
+	start := time.Now()
+	rep := Result{Name: filepath.Base(p), Path: p}
+	err := o.update(ctx, p, &rep)
+	rep.Duration = time.Since(start)
+	if o.Fetched != nil {
+		rep.Duration += o.Fetched.Took
+		rep.Downloaded += o.Fetched.Size
+		rep.Track(PhaseFetch, time.Now().Add(-o.Fetched.Took))
+	}
+	switch {
+	case err != nil && ctx.Err() != nil:
+		rep.Status = StatusInterrupted
+	case err != nil:
+		rep.Status = StatusFailed
+	case rep.Partial != "":
+		rep.Status = StatusPartial
+		err = errors.New(rep.Partial)
+	case rep.Status != "":
+	case rep.NewCommits > 0 && o.DryRun:
+		rep.Status = StatusPending
+	case rep.NewCommits > 0:
+		rep.Status = StatusUpdated
+	default:
+		rep.Status = StatusUpToDate
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s: %w", o.Timeout, err)
+		}
+		rep.Error = Scrub(err.Error())
+		o.debug(p, start, "failed: ", err)
+	}
+	return rep, err
+}
+
+func (o Options) update(parent context.Context, p string, rep *Result) error {
+	var (
+		r              *git.Repository
+		tag, head, tip *plumbing.Reference
+		rr             *git.Remote
+		err            error
+	)
+
+	ctx, cancel := o.networkContext(parent)
+	defer func() { cancel() }()
+
+	start := time.Now()
+	t := start
+	if r, err = git.PlainOpen(p); err != nil {
+		return err
+	}
+	o.debug(p, t, "opened ", p)
+	size := PackedSize(r)
+	defer func() { rep.Downloaded += max(PackedSize(r)-size, 0) }()
+	if o.Fetched != nil && o.Fetched.Err != nil {
+		return o.Fetched.Err
+	}
+	if o.MigrateTags && !o.DryRun {
+		t = time.Now()
+		if ok, err := MigrateGitTag(r); err != nil {
+			return err
+		} else if ok {
+			o.debug(p, t, "migrated tag ", TagName, " to ", MarkerRef)
+		}
+	}
+
+	t = time.Now()
+	head, err = r.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		// no commits yet, e.g. clone of a just added recipe
+		o.debug(p, t, "HEAD is unborn, fetching initial history")
+		return o.updateEmpty(ctx, p, r, rep)
+	}
+	if err != nil {
+		return err
+	}
+	o.debug(p, t, "HEAD is ", head.Name(), " at ", head.Hash())
+	if o.Pinned != "" {
+		// frozen by lockfile, pull would move it off the pin
+		rep.Status, rep.Pinned, rep.PinnedBy = StatusPinned, o.Pinned, o.PinnedBy
+		rep.PreviousHash = head.Hash().String()
+		rep.Drifted = !strings.HasPrefix(rep.PreviousHash, o.Pinned)
+		o.debug(p, t, "pinned at ", o.Pinned, " by ", o.PinnedBy, ", skipped")
+		return nil
+	}
+	if at, ok := o.fresh(r); ok {
+		rep.Status, rep.LastUpdate = StatusFresh, at
+		o.debug(p, t, "updated at ", at.Format(time.RFC3339), ", fresh, skipped")
+		return nil
+	}
+
+	t = time.Now()
+	rr, err = UpstreamRemote(r, head)
+	if err == ErrNoRemote {
+		rep.Status = StatusLocal
+		o.debug(p, t, "no remotes, skipped")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rep.RemoteURL = Scrub(rr.Config().URLs[0])
+	o.debug(p, t, rr.Config().Name, " is ", rep.RemoteURL)
+	tracking := TrackingBranch(r, head)
+	if tracking == nil && head.Name().IsBranch() {
+		o.debug(p, t, head.Name().Short(), " has no upstream configured, using ", rr.Config().Name)
+	}
+	rep.Track(PhaseOpen, start)
+
+	// the remote branch to pull, empty means remote HEAD
+	var branch plumbing.ReferenceName
+	if tracking != nil && tracking.Merge.IsBranch() {
+		branch = tracking.Merge
+	}
+	if o.Branch != "" {
+		branch = ConfiguredBranch(r, o.Branch, head)
+		if head.Name() != branch {
+			o.warn(rep.Name+": checked out", head.Name().Short()+", but configured branch is", branch.Short())
+			if !o.DryRun {
+				t = time.Now()
+				if head, err = SwitchBranch(ctx, r, rr, branch, o.Network); err != nil {
+					return err
+				}
+				o.debug(p, t, "switched to ", branch)
+				rep.Track(PhaseFetch, t)
+			}
+		}
+	}
+
+	if !head.Name().IsBranch() {
+		// pinned commit, pull would fail or move it off the pin
+		rep.Status = StatusDetached
+		if !o.UpdateDetached {
+			o.debug(p, t, "detached HEAD, skipped")
+			return nil
+		}
+		if err = o.fetch(ctx, p, rr, rep); err != nil {
+			return err
+		}
+		if tip, err = DefaultRemoteBranch(r, rr.Config().Name); err != nil {
+			return err
+		}
+		if _, rep.Behind, _, err = AheadBehind(r, head.Hash(), tip.Hash()); err != nil {
+			return err
+		}
+		rep.PreviousHash, rep.NewHash = head.Hash().String(), tip.Hash().String()
+		rep.upstream = tip.Name().Short()
+		return nil
+	}
+
+	if o.DryRun {
+		// compare HEAD (the commit the update marker would be set at) with
+		// the fetched remote branch, leave the worktree and the marker as is
+		tag = head
+		if err = o.fetch(ctx, p, rr, rep); err != nil {
+			return err
+		}
+
+		t = time.Now()
+		if tip, err = RemoteTrackingRef(r, rr.Config().Name, ConfiguredBranch(r, o.Branch, head)); err != nil {
+			return err
+		}
+		o.debug(p, t, "remote branch ", tip.Name(), " at ", tip.Hash())
+	} else {
+		forced := o.Force
+		if !o.Autostash && !forced {
+			// pull would fail or clobber the local edits, keep repo as is
+			w, err := r.Worktree()
+			if err != nil {
+				return err
+			}
+			if rep.DirtyFiles, err = DirtyFiles(w); err != nil {
+				return err
+			}
+			if n := len(rep.DirtyFiles); n > 0 {
+				slices.Sort(rep.DirtyFiles)
+				rep.Status = StatusDirty
+				o.warn(fmt.Sprintf("skipped %s: %d modified files (dirty worktree): %s",
+					rep.Name, n, strings.Join(rep.DirtyFiles, ", ")))
+				return nil
+			}
+		}
+
+		// upstream history is rewritten if the commit of the last update
+		// is not an ancestor of the fetched remote branch
+		synced := SyncedCommit(r, rr.Config().Name, cmp.Or(branch, head.Name()), head)
+
+		if o.Confirm != nil {
+			ok, err := o.Confirm(ctx, p, r, rr, head)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				o.debug(p, t, "declined")
+				rep.Status = StatusSkipped
+				return nil
+			}
+			rep.shown = true
+			// the answer may take a while, give the pull its own deadline
+			cancel()
+			ctx, cancel = o.networkContext(parent)
+		}
+
+		if o.BeforeMerge != nil {
+			if err = o.fetch(ctx, p, rr, rep); err != nil {
+				return err
+			}
+			upstream, err := RemoteTrackingRef(r, rr.Config().Name, cmp.Or(branch, head.Name()))
+			if err != nil {
+				return err
+			}
+			_, behind, _, err := AheadBehind(r, head.Hash(), upstream.Hash())
+			if err != nil {
+				return err
+			}
+			if behind > 0 {
+				if err = o.BeforeMerge(p, head.Hash(), upstream.Hash(), behind); err != nil {
+					return err
+				}
+			}
+		}
+
+		moveMarker := func() error {
+			t := time.Now()
+			if tag, err = SetUpdateMarker(r, head); err != nil {
+				return err
+			}
+			o.debug(p, t, "set update marker ", MarkerRef, " at ", tag.Hash())
+			return nil
+		}
+		if !forced {
+			if err = moveMarker(); err != nil {
+				return err
+			}
+		}
+
+		tagsBefore, err := TagNames(r)
+		if err != nil {
+			return err
+		}
+		if o.Fetched != nil {
+			tagsBefore = o.Fetched.Tags
+		}
+
+		var stash *object.Commit
+		if o.Autostash && !forced {
+			t = time.Now()
+			if stash, err = StashChanges(r, head); err != nil {
+				return fmt.Errorf("autostash: %w", err)
+			}
+			if stash != nil {
+				o.debug(p, t, "stashed local changes to ", StashRef)
+			}
+			rep.Track(PhaseMerge, t)
+		}
+
+		t = time.Now()
+		var updated bool
+		if forced {
+			// the marker keeps the commit before reset, move it only if the reset has succeeded
+			var discarded []plumbing.Hash
+			if discarded, updated, err = ForceResetGitRepo(ctx, r, rr, cmp.Or(branch, head.Name()), o.Network); err == nil {
+				err = moveMarker()
+			}
+			for _, v := range discarded {
+				rep.Discarded = append(rep.Discarded, v.String())
+			}
+			if len(discarded) > 0 {
+				rep.Status = StatusForced
+			}
+			o.debug(p, t, "force-reset to ", rr.Config().Name, ", discarded ", len(discarded), " commits")
+		} else {
+			if o.Fetched != nil {
+				updated, err = FastForwardGitChanges(r, rr.Config().Name, cmp.Or(branch, head.Name()))
+			} else {
+				updated, err = PullGitChanges(ctx, r, rr, branch, o.Network)
+			}
+			// the stale branch of renamed default one is up-to-date forever
+			// or gone from remote; the pull reports update of fetched refs
+			// too, compare HEAD
+			cur, herr := r.Head()
+			if herr == nil && cur.Hash() == head.Hash() && o.Branch == "" && branch != "" && (err == nil || errors.Is(err, plumbing.ErrReferenceNotFound)) {
+				t := time.Now()
+				var def plumbing.ReferenceName
+				var derr error
+				if o.Fetched != nil {
+					def, derr = renamedDefaultBranch(r, rr.Config().Name, branch, o.Fetched.Refs)
+				} else {
+					def, derr = DefaultBranchRenamed(ctx, r, rr, branch, o.Network)
+				}
+				switch {
+				case derr != nil:
+					o.debug(p, t, "cannot check default branch of ", rr.Config().Name, ": ", derr)
+				case def != "" && o.SwitchDefault:
+					rep.Default = def.Short()
+					var h *plumbing.Reference
+					if h, err = SwitchBranch(ctx, r, rr, def, o.Network); err == nil {
+						updated, rep.Switched, branch = h.Hash() != head.Hash(), true, def
+						o.debug(p, t, "default branch renamed, switched to ", def)
+					}
+				case def != "":
+					rep.Default = def.Short()
+					rep.Status, err = StatusAttention, nil
+					rep.Attention = fmt.Sprintf("default branch of %s is renamed to %s, %s does not advance anymore; --switch-default checks out %s",
+						rr.Config().Name, def.Short(), branch.Short(), def.Short())
+					o.warn(rep.Name+":", rep.Attention)
+				}
+			}
+		}
+		if errors.Is(err, git.ErrNonFastForwardUpdate) {
+			// the local branch has own commits, the pull has fetched upstream only
+			var upstream *plumbing.Reference
+			if upstream, err = RemoteTrackingRef(r, rr.Config().Name, cmp.Or(branch, head.Name())); err != nil {
+				return err
+			}
+			if rep.Ahead, rep.Behind, _, err = AheadBehind(r, head.Hash(), upstream.Hash()); err != nil {
+				return err
+			}
+			rep.upstream = upstream.Name().Short()
+			var rewritten bool
+			if rewritten, err = IsRewritten(r, synced, upstream.Hash()); err != nil {
+				return err
+			}
+			switch {
+			case rewritten:
+				// merge or rebase would mix the old history into the new one
+				rep.Status = StatusRewritten
+				if rep.rewrites, err = recentCommits(r, head, upstream, rewrittenCommits); err != nil {
+					return err
+				}
+				rep.Rewritten = NewCommitInfos(rep.rewrites)
+				// keep the synced commit, the rewrite is reported until
+				// it's resolved
+				err = r.Storer.SetReference(plumbing.NewHashReference(SyncedRef, synced))
+			case o.Rebase:
+				err = RebaseGitChanges(ctx, r, p, upstream.Name(), head.Hash())
+				if errors.Is(err, ErrRebaseAborted) {
+					rep.Status = StatusAttention
+					rep.Attention = err.Error()
+					o.warn(rep.Name+":", rep.Attention)
+					err = nil
+				} else if err == nil {
+					updated = true
+					o.debug(p, t, "diverged, rebased ", rep.Ahead, " commits onto ", upstream.Name().Short())
+				}
+			case o.Merge:
+				if err = MergeGitChanges(ctx, p, upstream.Name()); err == nil {
+					updated = true
+					o.debug(p, t, "diverged, merged ", upstream.Name().Short())
+				}
+			default:
+				rep.Status = StatusDiverged
+			}
+		}
+		rep.Track(PhaseMerge, t)
+		if stash != nil {
+			t = time.Now()
+			// reapply local changes even if the pull has failed
+			conflicts, uerr := UnstashChanges(r, stash)
+			if uerr != nil {
+				return errors.Join(err, fmt.Errorf("autostash, local changes are kept in %s: %w", StashRef, uerr))
+			}
+			if len(conflicts) > 0 {
+				rep.Status = StatusAttention
+				rep.Attention = fmt.Sprintf("local changes of %s conflict with the update, they are kept in %s",
+					strings.Join(conflicts, ", "), StashRef)
+				o.warn(rep.Name+":", rep.Attention)
+			}
+			rep.Track(PhaseMerge, t)
+		}
+		switch {
+		case err != nil:
+			o.debug(p, t, "pull failed: ", err)
+			return err
+		case rep.Status == StatusDiverged:
+			o.debug(p, t, "diverged, skipped")
+			return nil
+		case rep.Status == StatusRewritten:
+			o.debug(p, t, "upstream history rewritten, skipped")
+			return nil
+		case updated:
+			o.debug(p, t, "pulled, updated")
+		default:
+			o.debug(p, t, "pulled, already up-to-date")
+		}
+		if err = SetSyncedCommit(r, rr.Config().Name, cmp.Or(branch, head.Name())); err != nil {
+			return err
+		}
+
+		if tip, err = r.Head(); err != nil {
+			return err
+		}
+
+		if updated {
+			t = time.Now()
+			// the pull has succeeded, the repo is only partially updated on failure
+			if rep.Submodules, err = UpdateSubmodules(ctx, r, o.Depth); err != nil {
+				rep.Partial = "submodules: " + err.Error()
+				o.debug(p, t, "submodules update failed: ", err)
+			} else if rep.Submodules > 0 {
+				o.debug(p, t, "updated ", rep.Submodules, " submodules")
+			}
+			rep.Track(PhaseMerge, t)
+		}
+
+		t = time.Now()
+		if o.Fetched == nil {
+			if err = FetchGitTags(ctx, rr, o.Network); err != nil {
+				return err
+			}
+		}
+		tagsAfter, err := TagNames(r)
+		if err != nil {
+			return err
+		}
+		for _, v := range tagsAfter {
+			if !slices.Contains(tagsBefore, v) {
+				rep.NewReleases = append(rep.NewReleases, v)
+			}
+		}
+		o.debug(p, t, "fetched tags, ", len(rep.NewReleases), " new")
+		rep.Track(PhaseFetch, t)
+	}
+	rep.PreviousHash, rep.NewHash = tag.Hash().String(), tip.Hash().String()
+
+	t = time.Now()
+	if rep.log, rep.Truncated, err = CollectGitLog(r, tag, tip); err != nil {
+		return err
+	}
+	rep.Commits = NewCommitInfos(rep.log)
+	rep.NewCommits = len(rep.log)
+	for i, c := range rep.log {
+		if o.Breaking.Match(c.Message) {
+			rep.Commits[i].Breaking = true
+			rep.Breaking++
+		}
+	}
+	o.debug(p, t, "found ", rep.NewCommits, " new commits")
+	rep.Track(PhaseLog, t)
+	if rep.NewCommits == 0 {
+		return nil
+	}
+	rep.CompareURL = CompareURL(rep.RemoteURL, rep.PreviousHash, rep.NewHash)
+	t = time.Now()
+	if rep.Versions, err = VersionBumps(r, tag.Hash(), tip.Hash()); err != nil {
+		return err
+	}
+	o.debug(p, t, "found ", len(rep.Versions), " version bumps")
+	rep.Track(PhaseLog, t)
+	if o.Stat {
+		t = time.Now()
+		if rep.Stat, err = GetDiffStat(r, tag.Hash(), tip.Hash()); err != nil {
+			return err
+		}
+		o.debug(p, t, "diffstat of ", len(rep.Stat.Files), " files")
+		rep.Track(PhaseLog, t)
+	}
+	return nil
+}
+
+// Fetch history of repo p without commits and check out the default branch
+// of remote, all its commits are reported as the initial history; repo is
+// reported as empty if the remote has no commits either
+func (o Options) updateEmpty(ctx context.Context, p string, r *git.Repository, rep *Result) error {
+	rep.Status = StatusEmpty
+	t := time.Now()
+	rr, err := UpstreamRemote(r, nil)
+	if err == ErrNoRemote {
+		o.debug(p, t, "no remotes, empty")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rep.RemoteURL = Scrub(rr.Config().URLs[0])
+	err = FetchGitChanges(ctx, rr, o.Network)
+	if err == transport.ErrEmptyRemoteRepository {
+		o.debug(p, t, "remote is empty too")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	o.debug(p, t, "fetched")
+	rep.Track(PhaseFetch, t)
+
+	t = time.Now()
+	branch, _, err := RemoteDefaultBranch(ctx, rr, "", o.Network)
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		if ref, err := DefaultRemoteBranch(r, rr.Config().Name); err == nil {
+			branch = plumbing.NewBranchReferenceName(strings.TrimPrefix(ref.Name().Short(), rr.Config().Name+"/"))
+		}
+	}
+	if o.Branch != "" {
+		branch = plumbing.NewBranchReferenceName(o.Branch)
+	}
+	tip, err := RemoteTrackingRef(r, rr.Config().Name, branch)
+	if err != nil {
+		o.debug(p, t, "remote has no commits, empty")
+		return nil
+	}
+	if !o.DryRun {
+		if tip, err = SwitchBranch(ctx, r, rr, branch, o.Network); err != nil {
+			return err
+		}
+		if err = SetSyncedCommit(r, rr.Config().Name, branch); err != nil {
+			return err
+		}
+		o.debug(p, t, "checked out ", branch)
+		rep.Track(PhaseMerge, t)
+	}
+
+	t = time.Now()
+	rep.Status, rep.Initial = "", true
+	rep.NewHash = tip.Hash().String()
+	if rep.log, rep.Truncated, err = CollectGitLog(r, nil, tip); err != nil {
+		return err
+	}
+	rep.Commits = NewCommitInfos(rep.log)
+	rep.NewCommits = len(rep.log)
+	o.debug(p, t, "found ", rep.NewCommits, " commits of initial history")
+	rep.Track(PhaseLog, t)
+	return nil
+}
+
+// Time of the last update of repo and whether it's within MinAge
+func (o Options) fresh(r *git.Repository) (time.Time, bool) {
+	if o.MinAge <= 0 {
+		return time.Time{}, false
+	}
+	at := MarkerTime(r)
+	return at, !at.IsZero() && time.Since(at) < o.MinAge
+}
+
+// Fetch remote of repo p into its remote-tracking refs, unless it's fetched
+// already by FetchRepo
+func (o Options) fetch(ctx context.Context, p string, rr *git.Remote, rep *Result) error {
+	if o.Fetched != nil {
+		return nil
+	}
+	t := time.Now()
+	if err := FetchGitChanges(ctx, rr, o.Network); err != nil {
+		return err
+	}
+	o.debug(p, t, "fetched")
+	rep.Track(PhaseFetch, t)
+	return nil
+}
+
+// Render result of repo update as colored text to out
+func RenderResult(out io.Writer, rep Result, th Theme) error {
+	output := th.output()
+	th.remote = rep.RemoteURL
+	path := rep.Path
+	if th.Hyperlinks {
+		path = hyperlink(FileURL(rep.Path), rep.Path)
+	}
+	if len(rep.Discarded) > 0 && !th.Brief {
+		fmt.Fprintln(out, output.String(fmt.Sprintf("%s: force-reset, %d local commits discarded: %s",
+			rep.Name, len(rep.Discarded), strings.Join(rep.Discarded, " "))).Foreground(output.Color(th.Palette.Error)))
+	}
+
+	if rep.Switched {
+		fmt.Fprintln(out, output.String(rep.Name+": switched to", rep.Default+", the renamed default branch of upstream").Foreground(output.Color(th.Palette.Notice)))
+	}
+
+	releases := strings.Join(rep.NewReleases, ", ")
+	commits, span := strconv.Itoa(rep.NewCommits)+" new commits", ShortHash(rep.PreviousHash)+".."+ShortHash(rep.NewHash)
+	if rep.Initial {
+		commits, span = "initial history: "+strconv.Itoa(rep.NewCommits)+" commits", ShortHash(rep.NewHash)
+	}
+	switch {
+	case rep.Status == StatusFailed, rep.shown:
+	case rep.Status == StatusInterrupted:
+		fmt.Fprintln(out, output.String(rep.Name+": interrupted").Foreground(output.Color(th.Palette.Notice)))
+	case rep.Status == StatusLocal:
+		if !th.Brief {
+			fmt.Fprintln(out, output.String(rep.Name+":", ErrNoRemote.Error()).Faint())
+		}
+	case rep.Status == StatusEmpty:
+		if !th.Brief {
+			fmt.Fprintln(out, output.String(rep.Name+": empty, no commits upstream either").Faint())
+		}
+	case rep.Status == StatusPinned:
+		pin := fmt.Sprintf("%s: pinned at %s by %s", rep.Name, ShortHash(rep.Pinned), rep.PinnedBy)
+		if rep.Drifted {
+			fmt.Fprintln(out, output.String(pin+", but checked out", ShortHash(rep.PreviousHash), "(drifted)").Foreground(output.Color(th.Palette.Error)))
+		} else if !th.Brief {
+			fmt.Fprintln(out, output.String(pin).Faint())
+		}
+	case rep.Status == StatusDetached:
+		if rep.Behind > 0 {
+			fmt.Fprintln(out,
+				output.String(rep.Name, "pinned at", ShortHash(rep.PreviousHash)).Foreground(output.Color(th.Palette.Notice)),
+				output.String(strconv.Itoa(rep.Behind), "commits behind", rep.upstream).Foreground(output.Color(th.Palette.Count)),
+			)
+		}
+	case rep.Status == StatusDiverged:
+		if !th.Brief {
+			fmt.Fprintln(out, output.String(fmt.Sprintf("%s: diverged: %d ahead, %d behind %s",
+				rep.Name, rep.Ahead, rep.Behind, rep.upstream)).Foreground(output.Color(th.Palette.Error)))
+		}
+	case rep.Status == StatusRewritten:
+		fmt.Fprintln(out, output.String(fmt.Sprintf("%s: upstream history rewritten since last update, %d commits of %s are not in local history, --force-repo %s resets onto them",
+			rep.Name, rep.Behind, rep.upstream, rep.Name)).Foreground(output.Color(th.Palette.Error)))
+		if !th.Brief {
+			fmt.Fprintln(out, output.String("recent commits of", rep.upstream+":").Faint())
+			var buf bytes.Buffer
+			err := RenderGitLog(&buf, rep.rewrites, false, th)
+			out.Write(buf.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+	case th.OnlyBreaking && rep.Breaking == 0:
+	case rep.NewCommits == 0:
+		if releases != "" {
+			fmt.Fprintln(out, output.String(rep.Name, "new releases:", releases).Foreground(output.Color(th.Palette.Release)).Bold())
+		}
+	case th.Brief:
+		line := []any{
+			output.String(rep.Name).Foreground(output.Color(th.Palette.Header)),
+			output.String(commits).Foreground(output.Color(th.Palette.Count)),
+			output.String(span).Foreground(output.Color(th.Palette.Hash)),
+		}
+		if rep.Breaking > 0 {
+			line = append(line, output.String("⚠", strconv.Itoa(rep.Breaking), "breaking").Foreground(output.Color(th.Palette.Breaking)).Bold())
+		}
+		if releases != "" {
+			line = append(line, output.String("new releases:", releases).Foreground(output.Color(th.Palette.Release)).Bold())
+		}
+		for _, v := range rep.Versions {
+			line = append(line, output.String(v.Package, v.From, "→", v.To).Foreground(output.Color(th.Palette.Version)).Bold())
+		}
+		io.WriteString(out, fmt.Sprintln(line...))
+		if rep.Stat != nil {
+			fmt.Fprint(out, RenderDiffStat(rep.Stat, th))
+		}
+	default:
+		for _, v := range rep.Versions {
+			fmt.Fprintln(out, output.String(v.Package+":", v.From, "→", v.To).Foreground(output.Color(th.Palette.Version)).Bold())
+		}
+		fmt.Fprintln(out,
+			output.String("Fetched from", rep.RemoteURL).Foreground(output.Color(th.Palette.Header)),
+			output.String(commits).Foreground(output.Color(th.Palette.Count)),
+		)
+		if rep.CompareURL != "" {
+			fmt.Fprintln(out, output.String("compare:", rep.CompareURL).Foreground(output.Color(th.Palette.Path)))
+		}
+		if rep.Breaking > 0 {
+			fmt.Fprintln(out, output.String("⚠", strconv.Itoa(rep.Breaking), "commits look like breaking changes").Foreground(output.Color(th.Palette.Breaking)).Bold())
+		}
+		fmt.Fprintln(out, output.String("local path:", path).Foreground(output.Color(th.Palette.Path)).Faint())
+		if releases != "" {
+			fmt.Fprintln(out, output.String("new releases:", releases).Foreground(output.Color(th.Palette.Release)).Bold())
+		}
+		if rep.Partial != "" {
+			fmt.Fprintln(out, output.String("failed "+rep.Partial).Foreground(output.Color(th.Palette.Error)))
+		} else if rep.Submodules > 0 {
+			fmt.Fprintln(out, output.String(strconv.Itoa(rep.Submodules), "submodules updated").Foreground(output.Color(th.Palette.Updated)))
+		}
+		var buf bytes.Buffer
+		err := RenderGitLog(&buf, rep.log, rep.Truncated, th)
+		out.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if rep.Stat != nil {
+			fmt.Fprint(out, RenderDiffStat(rep.Stat, th))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+package straightup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestUpdateRepo(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	before := head(t, p)
+	l := up.commits(3)
+	ctx := context.Background()
+
+	res, err := UpdateRepo(ctx, p, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusUpdated || res.NewCommits != 3 || len(res.Commits) != 3 {
+		t.Errorf("update = %s with %d commits (%d listed), want %s with 3", res.Status, res.NewCommits, len(res.Commits), StatusUpdated)
+	}
+	if res.Name != "pkg" || res.PreviousHash != before.String() || res.NewHash != l[2].String() {
+		t.Errorf("update of %s moved %s → %s, want pkg %s → %s", res.Name, res.PreviousHash, res.NewHash, before, l[2])
+	}
+	if h := head(t, p); h != l[2] {
+		t.Errorf("HEAD is %s, want %s", h, l[2])
+	}
+	// the marker keeps the position before the update
+	if h := marked(t, p); h != before {
+		t.Errorf("update marker is at %s, want %s", h, before)
+	}
+
+	var buf bytes.Buffer
+	if err = RenderResult(&buf, res, Theme{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"3 new commits", "change a", "change c"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("rendered result misses %q:\n%s", s, buf.String())
+		}
+	}
+
+	// nothing new: no-op run is reported and the marker follows HEAD
+	if res, err = UpdateRepo(ctx, p, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusUpToDate || res.NewCommits != 0 {
+		t.Errorf("repeated update = %s with %d commits, want %s", res.Status, res.NewCommits, StatusUpToDate)
+	}
+	if h := marked(t, p); h != l[2] {
+		t.Errorf("update marker is at %s after no-op run, want %s", h, l[2])
+	}
+
+	// only commits after the marker are counted
+	up.commit("change d")
+	if res, err = UpdateRepo(ctx, p, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusUpdated || res.NewCommits != 1 {
+		t.Errorf("update = %s with %d commits, want %s with 1", res.Status, res.NewCommits, StatusUpdated)
+	}
+}
+
+func TestUpdateRepoFetched(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	l := up.commits(2)
+	ctx := context.Background()
+
+	f := FetchRepo(ctx, p, Options{})
+	if f == nil || f.Err != nil {
+		t.Fatalf("fetch = %+v", f)
+	}
+	if h := head(t, p); h == l[1] {
+		t.Error("fetch has moved HEAD")
+	}
+	// the merge does not reach the remote anymore
+	if err := os.RemoveAll(up.dir); err != nil {
+		t.Fatal(err)
+	}
+	res, err := UpdateRepo(ctx, p, Options{Fetched: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusUpdated || res.NewCommits != 2 || head(t, p) != l[1] {
+		t.Errorf("update of fetched repo = %s with %d commits, HEAD at %s, want %s with 2 at %s", res.Status, res.NewCommits, head(t, p), StatusUpdated, l[1])
+	}
+	if res.Downloaded != f.Size || f.Size == 0 {
+		t.Errorf("update downloaded %d bytes, fetch %d, want the same non-zero size", res.Downloaded, f.Size)
+	}
+
+	// failed fetch fails the update, nothing is merged
+	f = FetchRepo(ctx, p, Options{})
+	if f == nil || f.Err == nil {
+		t.Fatalf("fetch of removed remote = %+v, want error", f)
+	}
+	if res, err = UpdateRepo(ctx, p, Options{Fetched: f}); err == nil || res.Status != StatusFailed {
+		t.Errorf("update of failed fetch = %s, %v, want %s", res.Status, err, StatusFailed)
+	}
+}
+
+func TestUpdateRepoFresh(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	ctx := context.Background()
+	if _, err := UpdateRepo(ctx, p, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	before := head(t, p)
+	next := up.commit("change a")
+
+	res, err := UpdateRepo(ctx, p, Options{MinAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusFresh || res.LastUpdate.IsZero() || head(t, p) != before {
+		t.Errorf("update within min age = %s, last update %s, want %s with HEAD kept", res.Status, res.LastUpdate, StatusFresh)
+	}
+	if f := FetchRepo(ctx, p, Options{MinAge: time.Hour}); f != nil {
+		t.Errorf("fresh repo is fetched: %+v", f)
+	}
+	if res, err = UpdateRepo(ctx, p, Options{MinAge: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusUpdated || head(t, p) != next {
+		t.Errorf("update after min age = %s, want %s at %s", res.Status, StatusUpdated, next)
+	}
+}
+
+func TestUpdateRepoDryRun(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	before := head(t, p)
+	up.commits(2)
+
+	res, err := UpdateRepo(context.Background(), p, Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusPending || res.NewCommits != 2 {
+		t.Errorf("dry run = %s with %d commits, want %s with 2", res.Status, res.NewCommits, StatusPending)
+	}
+	if h := head(t, p); h != before {
+		t.Errorf("HEAD moved to %s by dry run, want %s", h, before)
+	}
+	if h := marked(t, p); !h.IsZero() {
+		t.Errorf("update marker is set at %s by dry run", h)
+	}
+}
+
+func TestUpdateRepoSkipped(t *testing.T) {
+	t.Run("local", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "pkg")
+		r, err := git.PlainInit(p, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		(&fixture{t: t, r: r, dir: p, when: epoch}).commit("init")
+		res, err := UpdateRepo(context.Background(), p, Options{})
+		if err != nil || res.Status != StatusLocal {
+			t.Errorf("update of repo without remotes = %s, %v, want %s", res.Status, err, StatusLocal)
+		}
+	})
+
+	t.Run("dirty", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		up.commit("upstream")
+		if err := writeFile(p, "pkg.el", "local edit\n"); err != nil {
+			t.Fatal(err)
+		}
+		var warnings bytes.Buffer
+		res, err := UpdateRepo(context.Background(), p, Options{Warnings: &warnings})
+		if err != nil || res.Status != StatusDirty {
+			t.Errorf("update of dirty repo = %s, %v, want %s", res.Status, err, StatusDirty)
+		}
+		if !strings.Contains(warnings.String(), "pkg.el") {
+			t.Errorf("warning misses the modified file: %q", warnings.String())
+		}
+	})
+
+	t.Run("detached", func(t *testing.T) {
+		up := newUpstream(t)
+		p := up.clone("pkg")
+		r, err := git.PlainOpen(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, head(t, p))); err != nil {
+			t.Fatal(err)
+		}
+		up.commit("upstream")
+		res, err := UpdateRepo(context.Background(), p, Options{})
+		if err != nil || res.Status != StatusDetached {
+			t.Errorf("update of detached repo = %s, %v, want %s", res.Status, err, StatusDetached)
+		}
+	})
+}
+
+func TestUpdateRepoFailed(t *testing.T) {
+	res, err := UpdateRepo(context.Background(), filepath.Join(t.TempDir(), "missing"), Options{})
+	if err == nil || res.Status != StatusFailed || res.Error == "" {
+		t.Errorf("update of missing repo = %s %q, %v, want %s", res.Status, res.Error, err, StatusFailed)
+	}
+}
+
+func TestUpdateRepoInterrupted(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	before := head(t, p)
+	up.commit("pending")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res, err := UpdateRepo(ctx, p, Options{})
+	if err == nil || res.Status != StatusInterrupted {
+		t.Errorf("update with canceled context = %s, %v, want %s", res.Status, err, StatusInterrupted)
+	}
+	if h := head(t, p); h != before {
+		t.Errorf("HEAD moved to %s by interrupted update, want %s", h, before)
+	}
+}
+
+func TestUpdateRepoBreaking(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	up.commit("fix: typo")
+	up.commit("feat!: new key bindings")
+
+	res, err := UpdateRepo(context.Background(), p, Options{Breaking: DefaultBreakingPatterns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Breaking != 1 || !res.Commits[0].Breaking || res.Commits[1].Breaking {
+		t.Errorf("update flagged %d commits (%+v), want the newest one", res.Breaking, res.Commits)
+	}
+}
+
+func TestUpdateRepoPinned(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	before := head(t, p)
+	up.commit("upstream")
+
+	res, err := UpdateRepo(context.Background(), p, Options{Pinned: before.String(), PinnedBy: "versions/default.el"})
+	if err != nil || res.Status != StatusPinned || res.Drifted {
+		t.Errorf("update of pinned repo = %s drifted %v, %v, want %s", res.Status, res.Drifted, err, StatusPinned)
+	}
+	if h := head(t, p); h != before {
+		t.Errorf("HEAD of pinned repo moved to %s, want %s", h, before)
+	}
+
+	res, err = UpdateRepo(context.Background(), p, Options{Pinned: strings.Repeat("0", 40), PinnedBy: "versions/default.el"})
+	if err != nil || res.Status != StatusPinned || !res.Drifted {
+		t.Errorf("update of repo off its pin = %s drifted %v, %v, want drifted", res.Status, res.Drifted, err)
+	}
+	var buf bytes.Buffer
+	if err = RenderResult(&buf, res, Theme{Brief: true}); err != nil || !strings.Contains(buf.String(), "(drifted)") {
+		t.Errorf("rendered drifted repo = %q, %v", buf.String(), err)
+	}
+}
+
+func TestUpdateRepoBeforeMerge(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	before := head(t, p)
+
+	var calls int
+	o := Options{BeforeMerge: func(_ string, from, to plumbing.Hash, commits int) error {
+		calls++
+		if from != before || commits != 2 {
+			t.Errorf("before merge of %s..%s, %d commits, want from %s, 2 commits", from, to, commits, before)
+		}
+		return errors.New("hook failed")
+	}}
+	// nothing to merge, nothing is called
+	if _, err := UpdateRepo(context.Background(), p, o); err != nil || calls != 0 {
+		t.Fatalf("update of up-to-date repo = %v, %d calls", err, calls)
+	}
+	up.commit("one")
+	up.commit("two")
+	res, err := UpdateRepo(context.Background(), p, o)
+	if err == nil || res.Status != StatusFailed || calls != 1 {
+		t.Errorf("update with failed hook = %s, %v, %d calls, want %s", res.Status, err, calls, StatusFailed)
+	}
+	if h := head(t, p); h != before {
+		t.Errorf("HEAD moved to %s despite failed hook", h)
+	}
+}
+
+func TestUpdateRepoRewritten(t *testing.T) {
+	up := newUpstream(t)
+	root, err := up.r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	up.commits(2)
+	p := up.clone("pkg")
+	before := head(t, p)
+
+	// force-push of upstream: its branch is rebuilt from the root commit
+	w, err := up.r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Reset(&git.ResetOptions{Commit: root.Hash(), Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	rewritten := up.commit("rewritten change")
+
+	res, err := UpdateRepo(context.Background(), p, Options{Merge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusRewritten || res.NewCommits != 0 || len(res.Rewritten) != 1 || res.Rewritten[0].Hash != rewritten.String() {
+		t.Errorf("update of rewritten upstream = %s, %d new commits, rewritten %v, want %s with %s", res.Status, res.NewCommits, res.Rewritten, StatusRewritten, rewritten)
+	}
+	if h := head(t, p); h != before {
+		t.Errorf("HEAD moved to %s by update of rewritten upstream, want %s", h, before)
+	}
+	if h := marked(t, p); h != before {
+		t.Errorf("update marker is at %s, want HEAD %s", h, before)
+	}
+	var buf bytes.Buffer
+	if err = RenderResult(&buf, res, Theme{}); err != nil || !strings.Contains(buf.String(), "upstream history rewritten") || !strings.Contains(buf.String(), "rewritten change") {
+		t.Errorf("rendered rewritten repo = %q, %v", buf.String(), err)
+	}
+	// reported again until it's resolved by force-reset
+	if res, err = UpdateRepo(context.Background(), p, Options{}); err != nil || res.Status != StatusRewritten {
+		t.Errorf("repeated update of rewritten upstream = %s, %v, want %s", res.Status, err, StatusRewritten)
+	}
+	if res, err = UpdateRepo(context.Background(), p, Options{Force: true}); err != nil || head(t, p) != rewritten {
+		t.Errorf("force-reset onto rewritten upstream = %s, %v, HEAD at %s", res.Status, err, head(t, p))
+	}
+
+	// own commits on top of upstream are not a rewrite
+	q := up.clone("other")
+	r, err := git.PlainOpen(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &fixture{t: t, r: r, dir: q, when: up.when}
+	local.commit("local change")
+	up.commit("upstream change")
+	if res, err = UpdateRepo(context.Background(), q, Options{}); err != nil || res.Status != StatusDiverged {
+		t.Errorf("update of diverged repo = %s, %v, want %s", res.Status, err, StatusDiverged)
+	}
+}
+
+func TestUpdateRepoDefaultRenamed(t *testing.T) {
+	up := newUpstream(t)
+	p := up.clone("pkg")
+	before := head(t, p)
+
+	// upstream renames master to main
+	main := plumbing.NewBranchReferenceName("main")
+	if err := up.r.Storer.SetReference(plumbing.NewHashReference(main, before)); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, main)); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.r.Storer.RemoveReference(plumbing.Master); err != nil {
+		t.Fatal(err)
+	}
+	next := up.commit("change of main")
+
+	res, err := UpdateRepo(context.Background(), p, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusAttention || res.Default != "main" || res.Switched || head(t, p) != before {
+		t.Errorf("update of renamed default branch = %s, default %q, switched %v, want %s with main", res.Status, res.Default, res.Switched, StatusAttention)
+	}
+
+	if res, err = UpdateRepo(context.Background(), p, Options{SwitchDefault: true}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusUpdated || !res.Switched || res.NewCommits != 1 || head(t, p) != next {
+		t.Errorf("switch to renamed default branch = %s, switched %v, %d commits, HEAD at %s, want %s with 1 commit at %s",
+			res.Status, res.Switched, res.NewCommits, head(t, p), StatusUpdated, next)
+	}
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := r.Head()
+	if err != nil || ref.Name() != main {
+		t.Fatalf("checked out %v, %v, want %s", ref, err, main)
+	}
+	if b := TrackingBranch(r, ref); b == nil || b.Merge != main {
+		t.Errorf("main tracks %v, want origin/main", b)
+	}
+}
+
+func TestUpdateRepoEmpty(t *testing.T) {
+	// just initialized clone with origin, no commits fetched yet
+	initEmpty := func(url string) string {
+		t.Helper()
+		p := filepath.Join(t.TempDir(), "pkg")
+		r, err := git.PlainInit(p, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{url}}); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	up := newUpstream(t)
+	next := up.commit("second")
+	p := initEmpty(up.dir)
+	res, err := UpdateRepo(context.Background(), p, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusUpdated || !res.Initial || res.NewCommits != 2 || res.PreviousHash != "" || head(t, p) != next {
+		t.Errorf("update of empty repo = %s, initial %v, %d commits, from %q, want %s with initial history of 2 commits at %s",
+			res.Status, res.Initial, res.NewCommits, res.PreviousHash, StatusUpdated, next)
+	}
+
+	empty := filepath.Join(t.TempDir(), "remote")
+	if _, err = git.PlainInit(empty, false); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = UpdateRepo(context.Background(), initEmpty(empty), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusEmpty || res.NewCommits != 0 {
+		t.Errorf("update of empty repo with empty upstream = %s, %d commits, want %s", res.Status, res.NewCommits, StatusEmpty)
+	}
+}
+
+func TestResultOutcome(t *testing.T) {
+	for status, want := range map[string]string{
+		StatusUpdated:     OutcomeUpdated,
+		StatusPending:     OutcomeUpdated,
+		StatusForced:      OutcomeUpdated,
+		StatusUpToDate:    OutcomeUnchanged,
+		StatusPartial:     OutcomeFailed,
+		StatusFailed:      OutcomeFailed,
+		StatusDirty:       OutcomeSkipped,
+		StatusInterrupted: OutcomeSkipped,
+	} {
+		if got := (Result{Status: status}).Outcome(); got != want {
+			t.Errorf("outcome of %s = %s, want %s", status, got, want)
+		}
+	}
+}
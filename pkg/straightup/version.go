@@ -0,0 +1,100 @@
+package straightup
+
+import (
+	"bufio"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Version header of elisp file, some MELPA packages use Package-Version
+var versionHeader = regexp.MustCompile(`^;+\s*(?:Package-)?Version:\s*(\S+)`)
+
+// Change of the Version header of package file
+type VersionBump struct {
+	Package string `json:"package"` // name of .el file without extension
+	File    string `json:"file"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// Version declared in the header of elisp file, empty if there is none; only
+// the leading comment block is inspected, so code mentioning "Version:" does
+// not count
+func PackageVersion(content string) string {
+	sc := bufio.NewScanner(strings.NewReader(content))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, ";") {
+			break
+		}
+		if m := versionHeader.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// Find .el files changed between two commits whose Version headers differ,
+// sorted by file name; added and removed files are not bumps
+func VersionBumps(r *git.Repository, from, to plumbing.Hash) ([]VersionBump, error) {
+	a, err := r.CommitObject(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.CommitObject(to)
+	if err != nil {
+		return nil, err
+	}
+	ta, err := a.Tree()
+	if err != nil {
+		return nil, err
+	}
+	tb, err := b.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(ta, tb)
+	if err != nil {
+		return nil, err
+	}
+
+	var l []VersionBump
+	for _, c := range changes {
+		if !strings.HasSuffix(c.From.Name, ".el") || !strings.HasSuffix(c.To.Name, ".el") {
+			continue
+		}
+		fa, fb, err := c.Files()
+		if err != nil {
+			return nil, err
+		}
+		old, err := fa.Contents()
+		if err != nil {
+			return nil, err
+		}
+		cur, err := fb.Contents()
+		if err != nil {
+			return nil, err
+		}
+		v1, v2 := PackageVersion(old), PackageVersion(cur)
+		if v1 == "" || v2 == "" || v1 == v2 {
+			continue
+		}
+		l = append(l, VersionBump{
+			Package: strings.TrimSuffix(path.Base(c.To.Name), ".el"),
+			File:    c.To.Name,
+			From:    v1,
+			To:      v2,
+		})
+	}
+	slices.SortFunc(l, func(a, b VersionBump) int { return strings.Compare(a.File, b.File) })
+	return l, nil
+}
@@ -0,0 +1,45 @@
+package straightup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackageVersion(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"header", ";;; magit.el --- A Git porcelain  -*- lexical-binding:t -*-\n\n;; Author: Jonas\n;; Version: 4.0.1\n", "4.0.1"},
+		{"package version", ";;; dash.el --- A modern list library\n;; Package-Version: 20240510.1327\n", "20240510.1327"},
+		{"spaces", ";;   Version:   1.2\n", "1.2"},
+		{"no header", ";;; foo.el --- Foo\n;; Author: Somebody\n", ""},
+		{"after code", ";;; foo.el --- Foo\n(defconst foo-version \"1.0\")\n;; Version: 2.0\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PackageVersion(tt.content); got != tt.want {
+				t.Errorf("version = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionBumps(t *testing.T) {
+	f := newMemFixture(t)
+	f.commitFile("lisp/magit.el", ";;; magit.el\n;; Version: 3.3.0\n", "magit 3.3.0")
+	f.commitFile("git-commit.el", ";;; git-commit.el\n;; Version: 3.3.0\n", "git-commit 3.3.0")
+	from := f.commitFile("README.md", "Version: 1\n", "readme")
+	f.commitFile("lisp/magit.el", ";;; magit.el\n;; Version: 4.0.1\n", "Release 4.0.1")
+	f.commitFile("git-commit.el", ";;; git-commit.el\n;; Version: 3.3.0\n;; Keywords: git\n", "keywords")
+	f.commitFile("README.md", "Version: 2\n", "readme")
+	to := f.commitFile("new.el", ";;; new.el\n;; Version: 0.1\n", "add new.el")
+
+	l, err := VersionBumps(f.r, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VersionBump{{Package: "magit", File: "lisp/magit.el", From: "3.3.0", To: "4.0.1"}}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("bumps = %+v, want %+v", l, want)
+	}
+}
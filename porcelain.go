@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Statuses of porcelain output, the same for every release
+const (
+	porcelainUpdated  = "updated"
+	porcelainUpToDate = "uptodate"
+	porcelainSkipped  = "skipped"
+	porcelainFailed   = "failed"
+)
+
+// Write a line per repo of tab-separated fields: status, name, old hash, new
+// hash, number of new commits, remote URL and error, "-" for missing values;
+// the layout is stable, new fields are only appended at the end
+func WritePorcelain(w io.Writer, reports []straightup.Result) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range reports {
+		status := porcelainSkipped
+		switch v.Outcome() {
+		case straightup.OutcomeUpdated:
+			status = porcelainUpdated
+		case straightup.OutcomeUnchanged:
+			status = porcelainUpToDate
+		case straightup.OutcomeFailed:
+			status = porcelainFailed
+		}
+		fields := []string{status, v.Name, v.PreviousHash, v.NewHash, strconv.Itoa(v.NewCommits), v.RemoteURL, v.Error}
+		for i, f := range fields {
+			fields[i] = porcelainField(f)
+		}
+		bw.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	return bw.Flush()
+}
+
+// Field of porcelain line: a single line without tabs, secrets are scrubbed
+func porcelainField(s string) string {
+	s = strings.Join(strings.Fields(straightup.Scrub(s)), " ")
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// The layout is a promise to scripts: change the test only by appending
+// fields to the end of lines
+func TestWritePorcelain(t *testing.T) {
+	reports := []straightup.Result{
+		{Name: "magit", Status: straightup.StatusUpdated, PreviousHash: "1111", NewHash: "2222", NewCommits: 3,
+			RemoteURL: "https://github.com/magit/magit.git", Commits: []straightup.CommitInfo{{Hash: "2222", Message: "Fix\n\nbody"}}},
+		{Name: "dash", Status: straightup.StatusUpToDate, PreviousHash: "3333", NewHash: "3333", RemoteURL: "https://github.com/magnars/dash.el"},
+		{Name: "evil", Status: straightup.StatusDirty, PreviousHash: "4444"},
+		{Name: "org", Status: straightup.StatusFailed, PreviousHash: "5555", RemoteURL: "https://git.savannah.gnu.org/git/emacs/org-mode.git",
+			Error: "fetch failed:\n\tconnection refused"},
+	}
+	var b strings.Builder
+	if err := WritePorcelain(&b, reports); err != nil {
+		t.Fatal(err)
+	}
+	want := "updated\tmagit\t1111\t2222\t3\thttps://github.com/magit/magit.git\t-\n" +
+		"uptodate\tdash\t3333\t3333\t0\thttps://github.com/magnars/dash.el\t-\n" +
+		"skipped\tevil\t4444\t-\t0\t-\t-\n" +
+		"failed\torg\t5555\t-\t0\thttps://git.savannah.gnu.org/git/emacs/org-mode.git\tfetch failed: connection refused\n"
+	if b.String() != want {
+		t.Errorf("porcelain output =\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Line "updating 57/213: magit…" redrawn in place while repos are updated,
+// "fetching 57/213: magit…" while their remotes are fetched
+type Progress struct {
+	total, done int
+	fetched     int    // repos fetched so far, all of them once merges start
+	current     string // the last started repo
+}
+
+// Printer with progress line of the running update, warnings are printed
+// above the line
+var activePrinter atomic.Pointer[RepoPrinter]
+
+// Printer of repo outputs of the update run with progress line if ShowProgress
+func NewRepoPrinter(total int) *RepoPrinter {
+	pr := &RepoPrinter{}
+	if ShowProgress() {
+		pr.progress = &Progress{total: total}
+		activePrinter.Store(pr)
+	}
+	return pr
+}
+
+// Report whether the progress line is shown: stdout is a terminal and no
+// quiet, JSON, check, interactive or verbose output is written there
+func ShowProgress() bool {
+	if *quiet || *jsonOutput || *check || *interactive || *verbose {
+		return false
+	}
+	return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+}
+
+// Erase progress line, the cursor is moved to its beginning
+func (p *Progress) Clear() {
+	output.ClearLine()
+	fmt.Fprint(os.Stdout, "\r")
+}
+
+// Draw progress line, the cursor stays at its end
+func (p *Progress) Draw() {
+	if p.fetched < p.total {
+		fmt.Fprint(os.Stdout, output.String(fmt.Sprintf("fetching %d/%d: %s…", p.fetched, p.total, p.current)).Faint())
+		return
+	}
+	fmt.Fprint(os.Stdout, output.String(fmt.Sprintf("updating %d/%d: %s…", p.done, p.total, p.current)).Faint())
+}
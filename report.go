@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Render Markdown report of the run finished at time at: header with totals,
+// a section per updated repo with its commits, failed and skipped repos in
+// their own sections
+func RenderMarkdownReport(w io.Writer, reports []straightup.Result, at time.Time, took time.Duration) error {
+	updated, failed, skipped, totals := splitReports(reports, took)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Emacs packages update %s\n\n", at.Format("2006-01-02 15:04"))
+	buf.WriteString(totals + "\n")
+
+	for _, v := range updated {
+		fmt.Fprintf(&buf, "\n## %s\n\n", v.Name)
+		fmt.Fprintf(&buf, "- Remote: %s\n", v.RemoteURL)
+		if v.CompareURL != "" {
+			fmt.Fprintf(&buf, "- Compare: %s\n", v.CompareURL)
+		}
+		if v.Initial {
+			fmt.Fprintf(&buf, "- Initial history: `%s`, %d commits\n", straightup.ShortHash(v.NewHash), v.NewCommits)
+		} else {
+			fmt.Fprintf(&buf, "- Change: `%s..%s`, %d new commits\n", straightup.ShortHash(v.PreviousHash), straightup.ShortHash(v.NewHash), v.NewCommits)
+		}
+		for _, b := range v.Versions {
+			fmt.Fprintf(&buf, "- Version: %s %s → %s\n", b.Package, b.From, b.To)
+		}
+		if len(v.NewReleases) > 0 {
+			fmt.Fprintf(&buf, "- Releases: %s\n", strings.Join(v.NewReleases, ", "))
+		}
+		if len(v.Discarded) > 0 {
+			fmt.Fprintf(&buf, "- Force-reset, discarded local commits: %s\n", strings.Join(v.Discarded, " "))
+		}
+		if len(v.Commits) == 0 {
+			continue
+		}
+		buf.WriteString("\n| Commit | Date | Author | Message |\n|---|---|---|---|\n")
+		for _, c := range v.Commits {
+			msg := markdownCell(strings.SplitN(c.Message, "\n", 2)[0])
+			if c.Breaking {
+				msg = "⚠ **" + msg + "**"
+			}
+			fmt.Fprintf(&buf, "| `%s` | %s | %s | %s |\n", straightup.ShortHash(c.Hash), c.Date.Format("2006-01-02"),
+				markdownCell(c.Author), msg)
+		}
+		if v.Truncated {
+			buf.WriteString("\nThe history is truncated by shallow fetch, the list may be incomplete.\n")
+		}
+	}
+
+	if len(failed) > 0 {
+		buf.WriteString("\n## Failed\n\n")
+		for _, v := range failed {
+			fmt.Fprintf(&buf, "- **%s**: %s\n", v.Name, v.Error)
+		}
+	}
+	if len(skipped) > 0 {
+		buf.WriteString("\n## Skipped\n\n")
+		for _, v := range skipped {
+			fmt.Fprintf(&buf, "- **%s**: %s\n", v.Name, skipReason(v))
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Split reports to updated, failed and skipped repos for report sections,
+// totals line of the report is returned too
+func splitReports(reports []straightup.Result, took time.Duration) (updated, failed, skipped []straightup.Result, totals string) {
+	var commits, unchanged int
+	for _, v := range reports {
+		switch v.Outcome() {
+		case straightup.OutcomeUpdated:
+			updated = append(updated, v)
+			commits += v.NewCommits
+		case straightup.OutcomeFailed:
+			failed = append(failed, v)
+		case straightup.OutcomeUnchanged:
+			unchanged++
+		default:
+			skipped = append(skipped, v)
+		}
+	}
+	verb := "updated"
+	if *dryRun {
+		verb = "with pending updates (dry run)"
+	}
+	totals = fmt.Sprintf("%d repos %s, %d new commits, %d failed, %d skipped, %d up to date, took %s.",
+		len(updated), verb, commits, len(failed), len(skipped), unchanged, took.Round(time.Millisecond))
+	return
+}
+
+// Render Org report of the run finished at time at, the same as Markdown
+// one: top-level heading with the date, a heading per updated repo linked to
+// its remote and tagged by the number of commits, which are listed by table;
+// successive reports appended to a file make a journal of updates
+func RenderOrgReport(w io.Writer, reports []straightup.Result, at time.Time, took time.Duration) error {
+	updated, failed, skipped, totals := splitReports(reports, took)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "* Emacs packages update [%s]\n", at.Format("2006-01-02 Mon 15:04"))
+	buf.WriteString(totals + "\n")
+
+	for _, v := range updated {
+		title := orgText(v.Name)
+		if v.RemoteURL != "" {
+			title = "[[" + v.RemoteURL + "][" + v.Name + "]]"
+		}
+		fmt.Fprintf(&buf, "\n** %s :commits_%d:\n", title, v.NewCommits)
+		if v.CompareURL != "" {
+			fmt.Fprintf(&buf, "- Compare: [[%s]]\n", v.CompareURL)
+		}
+		if v.Initial {
+			fmt.Fprintf(&buf, "- Initial history: =%s=, %d commits\n", straightup.ShortHash(v.NewHash), v.NewCommits)
+		} else {
+			fmt.Fprintf(&buf, "- Change: =%s..%s=, %d new commits\n", straightup.ShortHash(v.PreviousHash), straightup.ShortHash(v.NewHash), v.NewCommits)
+		}
+		for _, b := range v.Versions {
+			fmt.Fprintf(&buf, "- Version: %s %s → %s\n", orgText(b.Package), orgText(b.From), orgText(b.To))
+		}
+		if len(v.NewReleases) > 0 {
+			fmt.Fprintf(&buf, "- Releases: %s\n", orgText(strings.Join(v.NewReleases, ", ")))
+		}
+		if len(v.Discarded) > 0 {
+			fmt.Fprintf(&buf, "- Force-reset, discarded local commits: %s\n", strings.Join(v.Discarded, " "))
+		}
+		if len(v.Commits) == 0 {
+			continue
+		}
+		buf.WriteString("\n| Date | Commit | Author | Subject |\n|------+--------+--------+---------|\n")
+		for _, c := range v.Commits {
+			msg := orgCell(strings.SplitN(c.Message, "\n", 2)[0])
+			if c.Breaking {
+				msg = "⚠ *" + msg + "*"
+			}
+			fmt.Fprintf(&buf, "| %s | =%s= | %s | %s |\n", c.Date.Format("2006-01-02"), straightup.ShortHash(c.Hash),
+				orgCell(c.Author), msg)
+		}
+		if v.Truncated {
+			buf.WriteString("\nThe history is truncated by shallow fetch, the list may be incomplete.\n")
+		}
+	}
+
+	if len(failed) > 0 {
+		buf.WriteString("\n** Failed\n")
+		for _, v := range failed {
+			fmt.Fprintf(&buf, "- *%s*: %s\n", orgText(v.Name), orgText(v.Error))
+		}
+	}
+	if len(skipped) > 0 {
+		buf.WriteString("\n** Skipped\n")
+		for _, v := range skipped {
+			fmt.Fprintf(&buf, "- *%s*: %s\n", orgText(v.Name), orgText(strings.ReplaceAll(skipReason(v), "`", "")))
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Text of Org document without markup: zero width space is put before
+// emphasis markers which could open emphasis and between brackets of links
+// and macros, as Org has no escape character
+func orgText(s string) string {
+	const zwsp = "\u200b"
+	var b strings.Builder
+	var prev rune = ' '
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("*/_=~+", c) && (unicode.IsSpace(prev) || strings.ContainsRune(`-({'"`, prev)):
+			b.WriteString(zwsp)
+		case (c == '[' || c == '{') && prev == c:
+			b.WriteString(zwsp)
+		}
+		b.WriteRune(c)
+		prev = c
+	}
+	return b.String()
+}
+
+// Text of Org table cell, pipes would split the cell
+func orgCell(s string) string {
+	return strings.ReplaceAll(orgText(strings.TrimSpace(s)), "|", `\vert{}`)
+}
+
+// Text of table cell, pipes would split the cell
+func markdownCell(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "|", `\|`)
+}
+
+// Why repo was not updated, for the skipped section of report
+func skipReason(v straightup.Result) string {
+	switch v.Status {
+	case straightup.StatusDirty:
+		return "local changes: " + strings.Join(v.DirtyFiles, ", ")
+	case straightup.StatusDetached:
+		if v.Behind > 0 {
+			return "pinned / detached, " + strconv.Itoa(v.Behind) + " commits behind"
+		}
+		return "pinned / detached"
+	case straightup.StatusPinned:
+		if v.Drifted {
+			return "drifted: pinned at `" + straightup.ShortHash(v.Pinned) + "` by " + v.PinnedBy + ", checked out `" + straightup.ShortHash(v.PreviousHash) + "`"
+		}
+		return "pinned at `" + straightup.ShortHash(v.Pinned) + "` by " + v.PinnedBy
+	case straightup.StatusDiverged:
+		return fmt.Sprintf("diverged: %d ahead, %d behind", v.Ahead, v.Behind)
+	case straightup.StatusRewritten:
+		return fmt.Sprintf("upstream history rewritten, %d commits not in local history", v.Behind)
+	case straightup.StatusAttention:
+		return "needs attention: " + v.Attention
+	case straightup.StatusLocal:
+		return straightup.ErrNoRemote.Error()
+	case straightup.StatusEmpty:
+		return "empty, no commits upstream either"
+	case straightup.StatusFresh:
+		return "fresh, updated " + v.LastUpdate.Format("2006-01-02 15:04")
+	}
+	return v.Status
+}
+
+// Write data to file p atomically: to temp file of the same directory renamed
+// to p then; with appendTo data is added to the current content of p
+func WriteFileAtomic(p string, data []byte, appendTo bool) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(p); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if appendTo {
+		old, err := os.ReadFile(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if len(old) > 0 {
+			data = append(append(bytes.TrimRight(old, "\n"), "\n\n"...), data...)
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after rename
+	if _, err = f.Write(data); err == nil {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Write report of the run to --report file: Org one for .org files and
+// --format org, Markdown one otherwise
+func SaveReport(reports []straightup.Result, took time.Duration) error {
+	p, err := straightup.ExpandHome(*reportPath)
+	if err != nil {
+		return err
+	}
+	render := RenderMarkdownReport
+	if *format == "org" || strings.EqualFold(filepath.Ext(p), ".org") {
+		render = RenderOrgReport
+	}
+	var buf bytes.Buffer
+	if err = render(&buf, reports, time.Now(), took); err != nil {
+		return err
+	}
+	return WriteFileAtomic(p, buf.Bytes(), *reportAppend)
+}
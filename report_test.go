@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+func TestRenderMarkdownReport(t *testing.T) {
+	reports := []straightup.Result{
+		{
+			Name: "magit", Status: straightup.StatusUpdated, RemoteURL: "https://github.com/magit/magit.git",
+			PreviousHash: "1111111111", NewHash: "2222222222", NewCommits: 1,
+			Versions: []straightup.VersionBump{{Package: "magit", File: "lisp/magit.el", From: "3.3.0", To: "4.0.1"}},
+			Commits: []straightup.CommitInfo{{
+				Hash: "2222222222", Author: "Jonas <jonas@example.com>",
+				Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Message: "Fix a | b\n\nbody",
+			}},
+		},
+		{Name: "org", Status: straightup.StatusFailed, Error: "connection refused"},
+		{Name: "evil", Status: straightup.StatusDirty, DirtyFiles: []string{"evil.el"}},
+		{Name: "dash", Status: straightup.StatusUpToDate},
+	}
+	var buf bytes.Buffer
+	at := time.Date(2024, 5, 2, 10, 30, 0, 0, time.UTC)
+	if err := RenderMarkdownReport(&buf, reports, at, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		"# Emacs packages update 2024-05-02 10:30",
+		"1 repos updated, 1 new commits, 1 failed, 1 skipped, 1 up to date",
+		"## magit",
+		"- Remote: https://github.com/magit/magit.git",
+		"- Change: `111111..222222`",
+		"| `222222` | 2024-05-01 | Jonas <jonas@example.com> | Fix a \\| b |",
+		"## Failed\n\n- **org**: connection refused",
+		"## Skipped\n\n- **evil**: local changes: evil.el",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("report misses %q:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "\x1b[") || strings.Contains(out, "## dash") || strings.Contains(out, "body") {
+		t.Errorf("report has escape codes, up-to-date repo or commit body:\n%s", out)
+	}
+}
+
+func TestRenderOrgReport(t *testing.T) {
+	reports := []straightup.Result{
+		{
+			Name: "magit", Status: straightup.StatusUpdated, RemoteURL: "https://github.com/magit/magit.git",
+			PreviousHash: "1111111111", NewHash: "2222222222", NewCommits: 2,
+			Commits: []straightup.CommitInfo{
+				{Hash: "2222222222", Author: "Jonas", Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Message: "Use =foo= and *bar* | [[baz]]\n\nbody"},
+				{Hash: "3333333333", Author: "Kyle", Date: time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC), Message: "Rename foo_bar/baz"},
+			},
+		},
+		{Name: "org", Status: straightup.StatusFailed, Error: "connection refused"},
+		{Name: "dash", Status: straightup.StatusUpToDate},
+	}
+	var buf bytes.Buffer
+	at := time.Date(2024, 5, 2, 10, 30, 0, 0, time.UTC)
+	if err := RenderOrgReport(&buf, reports, at, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		"* Emacs packages update [2024-05-02 Thu 10:30]\n1 repos updated, 2 new commits, 1 failed",
+		"** [[https://github.com/magit/magit.git][magit]] :commits_2:",
+		"- Change: =111111..222222=, 2 new commits",
+		"| 2024-05-01 | =222222= | Jonas | Use \u200b=foo= and \u200b*bar* \\vert{} [\u200b[baz]] |",
+		"| 2024-04-30 | =333333= | Kyle | Rename foo_bar/baz |",
+		"** Failed\n- *org*: connection refused",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("report misses %q:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "dash") || strings.Contains(out, "body") {
+		t.Errorf("report has up-to-date repo or commit body:\n%s", out)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "report.md")
+	check := func(want string) {
+		t.Helper()
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("file has %q, want %q", b, want)
+		}
+	}
+
+	if err := WriteFileAtomic(p, []byte("# one\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	check("# one\n")
+	if err := WriteFileAtomic(p, []byte("# two\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	check("# one\n\n# two\n")
+
+	if err := os.Chmod(p, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(p, []byte("# three\n"), false); err != nil {
+		t.Fatal(err)
+	}
+	check("# three\n")
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode of rewritten file is %v, want 0600", fi.Mode().Perm())
+	}
+	if l, _ := filepath.Glob(filepath.Join(filepath.Dir(p), ".report.md.*")); len(l) > 0 {
+		t.Errorf("temp files are left: %v", l)
+	}
+}
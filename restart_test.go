@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muesli/termenv"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Runner recording invocations instead of running them, commands named in
+// fail return error
+type recordingRunner struct {
+	calls [][]string
+	fail  map[string]bool
+}
+
+func (r *recordingRunner) Run(name string, args ...string) error {
+	r.calls = append(r.calls, append([]string{name}, args...))
+	if r.fail[name] {
+		return errors.New("exit status 1")
+	}
+	return nil
+}
+
+// Set restart flags for the test, they are restored afterwards
+func setRestartFlags(t *testing.T, socket, server string, cmds ...string) {
+	t.Helper()
+	oldSocket, oldServer, oldCmds := *socketName, *serverFile, restartCmd
+	t.Cleanup(func() { *socketName, *serverFile, restartCmd = oldSocket, oldServer, oldCmds })
+	*socketName, *serverFile, restartCmd = socket, server, cmds
+}
+
+func TestRestartEmacs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	tests := []struct {
+		name           string
+		socket, server string
+		cmds           []string
+		want           [][]string
+	}{
+		{
+			name: "default",
+			want: [][]string{
+				{"emacsclient", "-w", "5", "-u", "-e", "t"},
+				{"emacsclient", "-e", "(kill-emacs)"},
+				{"emacs", "-nw", "--daemon"},
+			},
+		},
+		{
+			name:   "socket name",
+			socket: "work",
+			want: [][]string{
+				{"emacsclient", "-s", "work", "-w", "5", "-u", "-e", "t"},
+				{"emacsclient", "-s", "work", "-e", "(kill-emacs)"},
+				{"emacs", "-nw", "--daemon=work"},
+			},
+		},
+		{
+			name:   "server file",
+			server: "~/.emacs.d/server/alt",
+			want: [][]string{
+				{"emacsclient", "-f", filepath.Join(home, ".emacs.d/server/alt"), "-w", "5", "-u", "-e", "t"},
+				{"emacsclient", "-f", filepath.Join(home, ".emacs.d/server/alt"), "-e", "(kill-emacs)"},
+				{"emacs", "-nw", "--daemon=alt"},
+			},
+		},
+		{
+			name:   "socket name wins over server file name",
+			socket: "work",
+			server: "/tmp/server/alt",
+			want: [][]string{
+				{"emacsclient", "-s", "work", "-f", "/tmp/server/alt", "-w", "5", "-u", "-e", "t"},
+				{"emacsclient", "-s", "work", "-f", "/tmp/server/alt", "-e", "(kill-emacs)"},
+				{"emacs", "-nw", "--daemon=work"},
+			},
+		},
+		{
+			name:   "custom commands",
+			socket: "ignored",
+			cmds:   []string{`emacsclient -e "(kill-emacs)"`, "systemctl --user restart emacs"},
+			want: [][]string{
+				{"emacsclient", "-e", "(kill-emacs)"},
+				{"systemctl", "--user", "restart", "emacs"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRestartFlags(t, tt.socket, tt.server, tt.cmds...)
+			var run recordingRunner
+			if err := restartEmacs(&run); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(run.calls, tt.want) {
+				t.Errorf("restart ran %q, want %q", run.calls, tt.want)
+			}
+		})
+	}
+}
+
+// Runner failing the kill of Emacs daemon, which answers the probe
+type failingKillRunner struct{ recordingRunner }
+
+func (r *failingKillRunner) Run(name string, args ...string) error {
+	r.recordingRunner.Run(name, args...)
+	if slices.Contains(args, "(kill-emacs)") {
+		return errors.New("exit status 1")
+	}
+	return nil
+}
+
+func TestRestartEmacsWindows(t *testing.T) {
+	setRestartFlags(t, "work", "")
+	old := targetOS
+	t.Cleanup(func() { targetOS = old })
+	targetOS = "windows"
+
+	var run recordingRunner
+	if err := restartEmacs(&run); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"emacsclientw", "-s", "work", "-w", "5", "-u", "-e", "t"},
+		{"emacsclientw", "-s", "work", "-e", "(kill-emacs)"},
+		{"runemacs", "--daemon=work"},
+	}
+	if !reflect.DeepEqual(run.calls, want) {
+		t.Errorf("restart ran %q, want %q", run.calls, want)
+	}
+}
+
+func TestRestartEmacsFailure(t *testing.T) {
+	setRestartFlags(t, "", "")
+	// the daemon is started even if the kill fails
+	var run failingKillRunner
+	if err := restartEmacs(&run); err != nil || len(run.calls) != 3 {
+		t.Errorf("restart with failed kill = %v, ran %q, want the daemon started", err, run.calls)
+	}
+
+	run2 := recordingRunner{fail: map[string]bool{"emacs": true}}
+	err := restartEmacs(&run2)
+	if err == nil || !strings.Contains(err.Error(), "emacs -nw --daemon") {
+		t.Errorf("restart error = %v, want failed emacs command", err)
+	}
+}
+
+func TestRestartEmacsNotRunning(t *testing.T) {
+	setRestartFlags(t, "", "")
+	old := *startDaemon
+	t.Cleanup(func() { *startDaemon = old })
+
+	// failed probe: nothing to kill, the daemon is not started without --start-daemon
+	*startDaemon = false
+	run := recordingRunner{fail: map[string]bool{"emacsclient": true}}
+	if err := restartEmacs(&run); err != nil || len(run.calls) != 1 {
+		t.Errorf("restart of stopped daemon = %v, ran %q, want only the probe", err, run.calls)
+	}
+
+	*startDaemon = true
+	run = recordingRunner{fail: map[string]bool{"emacsclient": true}}
+	want := [][]string{{"emacsclient", "-w", "5", "-u", "-e", "t"}, {"emacs", "-nw", "--daemon"}}
+	if err := restartEmacs(&run); err != nil || !reflect.DeepEqual(run.calls, want) {
+		t.Errorf("restart with --start-daemon = %v, ran %q, want %q", err, run.calls, want)
+	}
+}
+
+func TestRestartEmacsBadCommand(t *testing.T) {
+	setRestartFlags(t, "", "", `emacsclient -e "(kill-emacs)`)
+	var run recordingRunner
+	if err := restartEmacs(&run); err == nil || len(run.calls) != 0 {
+		t.Errorf("restart with unterminated quote = %v, ran %q, want error and nothing run", err, run.calls)
+	}
+}
+
+func TestRestartEmacsDoomSync(t *testing.T) {
+	setRestartFlags(t, "", "")
+	oldManager, oldBin, oldSync := manager, doomBin, *doomSync
+	t.Cleanup(func() { manager, doomBin, *doomSync = oldManager, oldBin, oldSync })
+	manager, doomBin, *doomSync = straightup.ManagerDoom, "/home/u/.config/emacs/bin/doom", true
+
+	var run recordingRunner
+	if err := restartEmacs(&run); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"/home/u/.config/emacs/bin/doom", "sync"},
+		{"emacsclient", "-w", "5", "-u", "-e", "t"},
+		{"emacsclient", "-e", "(kill-emacs)"},
+		{"emacs", "-nw", "--daemon"},
+	}
+	if !reflect.DeepEqual(run.calls, want) {
+		t.Errorf("restart ran %q, want %q", run.calls, want)
+	}
+}
+
+func TestRebuildPackages(t *testing.T) {
+	setRestartFlags(t, "work", "")
+	oldDir := *reposDir
+	t.Cleanup(func() { *reposDir = oldDir })
+	*reposDir = filepath.Join(t.TempDir(), "repos") // no builds, repo names are taken as packages
+
+	var run recordingRunner
+	form, err := rebuildPackages(&run, []string{"magit", "dash.el"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `(progn (straight-rebuild-package "dash") (straight-rebuild-package "magit"))`
+	if form != want || !reflect.DeepEqual(run.calls, [][]string{{"emacsclient", "-s", "work", "-e", want}}) {
+		t.Errorf("rebuild ran %q with form %s, want %s", run.calls, form, want)
+	}
+
+	run = recordingRunner{fail: map[string]bool{"emacsclient": true}}
+	if form, err = rebuildPackages(&run, []string{"magit"}); err == nil || form == "" {
+		t.Errorf("failed rebuild = %q, %v, want error and the form", form, err)
+	}
+}
+
+func TestSplitRestartRepos(t *testing.T) {
+	old := restartRepos
+	t.Cleanup(func() { restartRepos = old })
+	changed := []string{"magit", "org", "straight.el"}
+
+	restartRepos = nil
+	if restart, other := SplitRestartRepos(changed); !reflect.DeepEqual(restart, changed) || other != nil {
+		t.Errorf("without restart_repos split = %v %v, want all to restart", restart, other)
+	}
+	restartRepos = []string{"straight.el", "org", "use-package"}
+	restart, other := SplitRestartRepos(changed)
+	if !reflect.DeepEqual(restart, []string{"org", "straight.el"}) || !reflect.DeepEqual(other, []string{"magit"}) {
+		t.Errorf("split = %v %v, want [org straight.el] [magit]", restart, other)
+	}
+	if restart, _ = SplitRestartRepos([]string{"magit"}); restart != nil {
+		t.Errorf("restart for non-core repo: %v", restart)
+	}
+}
+
+func TestParseIdleTime(t *testing.T) {
+	if d, err := parseIdleTime("754.25\n"); err != nil || d != 754250*time.Millisecond {
+		t.Errorf("idle time = %s, %v, want 12m34.25s", d, err)
+	}
+	if d, err := parseIdleTime("0.0\n"); err != nil || d != 0 {
+		t.Errorf("idle time of busy Emacs = %s, %v, want 0", d, err)
+	}
+	if _, err := parseIdleTime("*ERROR*: Symbol’s function definition is void\n"); err == nil {
+		t.Error("idle time of error output is parsed")
+	}
+}
+
+// Writer failing every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestColoredWriter(t *testing.T) {
+	old := output
+	t.Cleanup(func() { output = old })
+	var b strings.Builder
+	output = termenv.NewOutput(&b, termenv.WithProfile(termenv.ANSI256))
+
+	mu := new(sync.Mutex)
+	stdout := NewColoredWriter(&b, output.Color("147"), "emacs: ", mu)
+	stderr := NewColoredWriter(&b, output.Color("175"), "emacs: ", mu)
+	for _, v := range []struct {
+		w *ColoredWriter
+		s string
+	}{{stdout, "Loading "}, {stderr, "warn"}, {stdout, "init.el\nStarting"}, {stderr, "ing\n"}, {stdout, " server\r\n"}, {stdout, "done"}} {
+		if n, err := v.w.Write([]byte(v.s)); n != len(v.s) || err != nil {
+			t.Fatalf("write of %q = %d, %v", v.s, n, err)
+		}
+	}
+	if err := stdout.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out, want := b.String(), "\x1b[38;5;147memacs: Loading init.el\x1b[0m\n"+
+		"\x1b[38;5;175memacs: warning\x1b[0m\n"+
+		"\x1b[38;5;147memacs: Starting server\x1b[0m\n"+
+		"\x1b[38;5;147memacs: done\x1b[0m\n"
+	if out != want {
+		t.Errorf("colored output = %q, want %q", out, want)
+	}
+
+	w := NewColoredWriter(failingWriter{}, nil, "", nil)
+	if n, err := w.Write([]byte("line\n")); n != 0 || err == nil {
+		t.Errorf("write to failing writer = %d, %v, want 0 and error", n, err)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{limit: 10}
+	for _, s := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("write of %q = %d, %v", s, n, err)
+		}
+	}
+	if string(b.buf) != "four\n" || b.dropped != 14 {
+		t.Errorf("capped buffer has %q, %d bytes dropped, want the last line and 14 bytes", b.buf, b.dropped)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Another run holds the run lock
+var ErrLocked = errors.New("another updstraight run is in progress")
+
+// Lock file of the running update, kept open until exit: the lock is gone
+// with the file
+var runLock *os.File
+
+// Interval of attempts to take the run lock held by another run
+const lockPoll = 500 * time.Millisecond
+
+// Path of run lock file of state directory
+func RunLockPath() (string, error) {
+	d, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "lock"), nil
+}
+
+// Take exclusive lock of file p for the run, waiting up to wait until the
+// run holding it finishes; the system releases the lock on exit of the
+// process, on crash and signals too, so locks of dead processes never get
+// stale; the pid of the holder is written to the file for messages
+func AcquireRunLock(ctx context.Context, p string, wait time.Duration) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			if pid := lockHolder(p); pid > 0 {
+				return nil, fmt.Errorf("%w (pid %d)", ErrLocked, pid)
+			}
+			return nil, ErrLocked
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Pid written by the holder of lock file p, 0 if it's unknown
+func lockHolder(p string) int {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}
+
+// Take the run lock for the whole run, waiting up to --lock-wait for the run
+// holding it; exit with ExitLocked if it's still held then
+func LockRun(ctx context.Context) {
+	p, err := RunLockPath()
+	if err != nil {
+		fatal(err)
+	}
+	if *lockWait > 0 {
+		debug("lock", time.Now(), "waiting up to ", *lockWait, " for ", p)
+	}
+	runLock, err = AcquireRunLock(ctx, p, *lockWait)
+	switch {
+	case errors.Is(err, ErrLocked):
+		log.Printf("%s, lock %s is held; retry later or wait for it with --lock-wait", err, p)
+		os.Exit(ExitLocked)
+	case errors.Is(err, context.Canceled):
+		os.Exit(ExitInterrupted)
+	case err != nil:
+		fatalf("cannot lock %s: %s", p, err)
+	}
+}
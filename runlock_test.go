@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireRunLock(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "state", "lock")
+	f, err := AcquireRunLock(context.Background(), p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(p); strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want pid %d", b, os.Getpid())
+	}
+
+	start := time.Now()
+	if _, err = AcquireRunLock(context.Background(), p, lockPoll); !errors.Is(err, ErrLocked) {
+		t.Errorf("second lock = %v, want %v", err, ErrLocked)
+	} else if time.Since(start) < lockPoll {
+		t.Errorf("second lock has failed after %s, want wait of %s", time.Since(start), lockPoll)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = AcquireRunLock(ctx, p, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled lock wait = %v, want %v", err, context.Canceled)
+	}
+
+	f.Close() // lock of exited run is released with its file
+	f, err = AcquireRunLock(context.Background(), p, 0)
+	if err != nil {
+		t.Fatalf("lock after release = %v", err)
+	}
+	f.Close()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Property list of elisp, keywords alternate with their values
+type plist []any
+
+// Add property k of value v to the list, zero v is left out unless always is
+// set, so optional properties read as nil by plist-get
+func (p plist) add(k string, v any, always bool) plist {
+	if !always {
+		switch v := v.(type) {
+		case string:
+			if v == "" {
+				return p
+			}
+		case int:
+			if v == 0 {
+				return p
+			}
+		case bool:
+			if !v {
+				return p
+			}
+		case []string:
+			if len(v) == 0 {
+				return p
+			}
+		}
+	}
+	return append(p, k, v)
+}
+
+// Write run report as elisp s-expression for read of Emacs: a plist with
+// :restart-needed and :repos, plist of every repo with :log of its new
+// commits; the output is plain ASCII, other characters are escaped
+func WriteSexp(w io.Writer, rr RunReport) error {
+	repos := make([]any, len(rr.Repos))
+	for i, v := range rr.Repos {
+		repos[i] = sexpResult(v)
+	}
+	p := plist{}.
+		add(":restart-needed", rr.RestartNeeded, true).
+		add(":restart-repos", rr.RestartRepos, false).
+		add(":interrupted", rr.Interrupted, false).
+		add(":excluded", rr.Excluded, true).
+		add(":duration", rr.Duration, true).
+		add(":downloaded", rr.Downloaded, true).
+		add(":repos", repos, true)
+
+	var b strings.Builder
+	writeSexp(&b, p)
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Plist of repo report
+func sexpResult(v straightup.Result) plist {
+	commits := make([]any, len(v.Commits))
+	for i, c := range v.Commits {
+		commits[i] = plist{}.
+			add(":hash", c.Hash, true).
+			add(":author", c.Author, true).
+			add(":date", c.Date.Format(time.RFC3339), true).
+			add(":message", c.Message, true).
+			add(":breaking", c.Breaking, false)
+	}
+	return plist{}.
+		add(":repo", v.Name, true).
+		add(":path", v.Path, true).
+		add(":status", v.Status, true).
+		add(":commits", v.NewCommits, true).
+		add(":old", v.PreviousHash, false).
+		add(":new", v.NewHash, false).
+		add(":compare-url", v.CompareURL, false).
+		add(":releases", v.NewReleases, false).
+		add(":breaking", v.Breaking, false).
+		add(":attention", v.Attention, false).
+		add(":error", v.Error, false).
+		add(":log", commits, true)
+}
+
+// Write v as elisp: strings, integers, booleans as t and nil, durations as
+// float seconds, lists of them and plists
+func writeSexp(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case string:
+		b.WriteString(sexpString(v))
+	case int:
+		b.WriteString(strconv.Itoa(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case bool:
+		if v {
+			b.WriteString("t")
+		} else {
+			b.WriteString("nil")
+		}
+	case time.Duration:
+		b.WriteString(strconv.FormatFloat(v.Seconds(), 'f', 3, 64))
+	case []string:
+		l := make([]any, len(v))
+		for i, s := range v {
+			l[i] = s
+		}
+		writeSexp(b, l)
+	case plist:
+		b.WriteByte('(')
+		for i := 0; i < len(v); i += 2 {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(v[i].(string) + " ")
+			writeSexp(b, v[i+1])
+		}
+		b.WriteByte(')')
+	case []any:
+		if len(v) == 0 {
+			b.WriteString("nil")
+			return
+		}
+		b.WriteByte('(')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writeSexp(b, e)
+		}
+		b.WriteByte(')')
+	default:
+		panic(fmt.Sprintf("no elisp syntax of %T", v))
+	}
+}
+
+// Elisp string literal of s in ASCII: quotes and backslashes are escaped,
+// control characters by octal escapes, the rest of non-ASCII ones by \u and
+// \U escapes of their code points
+func sexpString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		case c < 0x80:
+			b.WriteRune(c)
+		case c <= 0xffff:
+			fmt.Fprintf(&b, `\u%04X`, c)
+		default:
+			fmt.Fprintf(&b, `\U%08X`, c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
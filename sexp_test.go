@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+func TestSexpString(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", `"plain"`},
+		{"say \"hi\"\\now", `"say \"hi\"\\now"`},
+		{"subject\n\nbody\there", `"subject\n\nbody\there"`},
+		{"\x1b[31mred", `"\033[31mred"`},
+		{"Füße – ✓", `"F\u00FC\u00DFe \u2013 \u2713"`},
+		{"emoji 🎉", `"emoji \U0001F389"`},
+	}
+	for _, tt := range tests {
+		if got := sexpString(tt.in); got != tt.want {
+			t.Errorf("sexpString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSexp(t *testing.T) {
+	rr := RunReport{
+		RestartNeeded: true,
+		RestartRepos:  []string{"magit"},
+		Duration:      1500 * time.Millisecond,
+		Repos: []straightup.Result{
+			{Name: "magit", Path: "/repos/magit", Status: straightup.StatusUpdated, NewCommits: 1, PreviousHash: "abc123", NewHash: "def456",
+				Commits: []straightup.CommitInfo{{Hash: "def456", Author: "A <a@e.x>", Date: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC), Message: "Fix \"x\"\n"}}},
+			{Name: "org", Path: "/repos/org", Status: straightup.StatusUpToDate, Commits: []straightup.CommitInfo{}},
+		},
+	}
+	var b strings.Builder
+	if err := WriteSexp(&b, rr); err != nil {
+		t.Fatal(err)
+	}
+	want := `(:restart-needed t :restart-repos ("magit") :excluded 0 :duration 1.500 :downloaded 0 :repos (` +
+		`(:repo "magit" :path "/repos/magit" :status "updated" :commits 1 :old "abc123" :new "def456" ` +
+		`:log ((:hash "def456" :author "A <a@e.x>" :date "2026-10-14T12:00:00Z" :message "Fix \"x\"\n"))) ` +
+		`(:repo "org" :path "/repos/org" :status "up-to-date" :commits 0 :log nil)))` + "\n"
+	if b.String() != want {
+		t.Errorf("sexp =\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/muesli/termenv"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Print aligned table of repo results sorted by number of commits, up-to-date
+// repos are collapsed into a single line unless showUnchanged is set
+func PrintSummary(reports []straightup.Result, showUnchanged bool) {
+	rows := make([]straightup.Result, 0, len(reports))
+	unchanged, fresh := 0, 0
+	var dirty, stopped []string
+	for _, v := range reports {
+		if v.Status == straightup.StatusFresh && !showUnchanged {
+			fresh++
+			continue
+		}
+		switch v.Status {
+		case straightup.StatusDirty:
+			dirty = append(dirty, v.Name)
+		case straightup.StatusInterrupted:
+			stopped = append(stopped, v.Name)
+		}
+		if v.Status == straightup.StatusUpToDate && !showUnchanged {
+			unchanged++
+			continue
+		}
+		rows = append(rows, v)
+	}
+	slices.SortStableFunc(rows, func(a, b straightup.Result) int {
+		return cmp.Compare(b.NewCommits, a.NewCommits)
+	})
+
+	if len(rows) > 0 {
+		// align plain text first, escape sequences would break the widths
+		var buf bytes.Buffer
+		tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "REPO\tCOMMITS\tCHANGE\tDURATION\tSTATUS")
+		for _, v := range rows {
+			status := v.Status
+			switch v.Status {
+			case straightup.StatusDetached:
+				status = "pinned / detached — skipped"
+				if v.Behind > 0 {
+					status += ", " + strconv.Itoa(v.Behind) + " behind"
+				}
+			case straightup.StatusPinned:
+				status = "pinned by " + v.PinnedBy + " — skipped"
+				if v.Drifted {
+					status = "drifted from pin of " + v.PinnedBy
+				}
+			case straightup.StatusDiverged:
+				status = fmt.Sprintf("diverged: %d ahead, %d behind", v.Ahead, v.Behind)
+			case straightup.StatusRewritten:
+				status = fmt.Sprintf("upstream history rewritten, %d commits", v.Behind)
+			case straightup.StatusAttention:
+				if v.Default != "" {
+					status = "default branch renamed to " + v.Default + " — not updated"
+				}
+			case straightup.StatusForced:
+				status = fmt.Sprintf("force-reset, %d local commits discarded", len(v.Discarded))
+			case straightup.StatusEmpty:
+				status = "empty"
+			case straightup.StatusFresh:
+				status = "fresh, skipped: updated " + v.LastUpdate.Format("15:04")
+			}
+			if v.Initial {
+				status += ", initial history"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s→%s\t%s\t%s\n",
+				v.Name, v.NewCommits, straightup.ShortHash(v.PreviousHash), straightup.ShortHash(v.NewHash),
+				v.Duration.Round(time.Millisecond), status)
+		}
+		tw.Flush()
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		fmt.Println(output.String(lines[0]).Bold())
+		for i, l := range lines[1:] {
+			fmt.Println(statusStyle(output.String(l), rows[i].Status))
+		}
+	}
+	if unchanged > 0 {
+		fmt.Println(output.String(strconv.Itoa(unchanged), "repos are up to date").Faint())
+	}
+	if fresh > 0 {
+		fmt.Println(output.String(strconv.Itoa(fresh), "repos are fresh, skipped: updated within", shortDuration(*minAge)).Faint())
+	}
+	if len(dirty) > 0 {
+		slices.Sort(dirty)
+		fmt.Println(output.String(strconv.Itoa(len(dirty)), "repos with local changes were not updated:",
+			strings.Join(dirty, ", ")).Foreground(output.Color(palette.Notice)))
+	}
+	var flagged, drifted []string
+	for _, v := range rows {
+		if v.Breaking > 0 {
+			flagged = append(flagged, fmt.Sprintf("%s (%d)", v.Name, v.Breaking))
+		}
+		if v.Drifted {
+			drifted = append(drifted, v.Name)
+		}
+	}
+	if len(flagged) > 0 {
+		fmt.Println(output.String("⚠ Repos with breaking changes:", strings.Join(flagged, ", ")).Foreground(output.Color(palette.Breaking)).Bold())
+	}
+	if len(drifted) > 0 {
+		fmt.Println(output.String(strconv.Itoa(len(drifted)), "repos are not at commits pinned by lockfile:",
+			strings.Join(drifted, ", ")).Foreground(output.Color(palette.Error)))
+	}
+	if len(stopped) > 0 {
+		slices.Sort(stopped)
+		fmt.Println(output.String(strconv.Itoa(len(stopped)), "repos were interrupted, run again to update them:",
+			strings.Join(stopped, ", ")).Foreground(output.Color(palette.Notice)))
+	}
+}
+
+// Duration without zero minutes and seconds, e.g. 6h instead of 6h0m0s
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// Human readable size of n bytes, e.g. 14.2 MiB
+func FormatBytes(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	v, unit := float64(n)/1024, "KiB"
+	for _, u := range []string{"MiB", "GiB"} {
+		if v < 1024 {
+			break
+		}
+		v, unit = v/1024, u
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64) + " " + unit
+}
+
+// Total bytes downloaded by reports and the number of repos downloading them
+func Downloaded(reports []straightup.Result) (total int64, repos int) {
+	for _, v := range reports {
+		if v.Downloaded > 0 {
+			total += v.Downloaded
+			repos++
+		}
+	}
+	return
+}
+
+// Number of repos listed by PrintDownloads in verbose mode
+const heaviestRepos = 5
+
+// Print total size of fetched packfiles, with the repos downloading the most
+// if verbose is set
+func PrintDownloads(reports []straightup.Result, verbose bool) {
+	total, repos := Downloaded(reports)
+	if repos == 0 {
+		return
+	}
+	fmt.Println(output.String("downloaded", FormatBytes(total), "across", strconv.Itoa(repos), "repos").Faint())
+	if !verbose {
+		return
+	}
+	rows := slices.Clone(reports)
+	slices.SortStableFunc(rows, func(a, b straightup.Result) int {
+		return cmp.Compare(b.Downloaded, a.Downloaded)
+	})
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, v := range rows[:min(repos, heaviestRepos)] {
+		fmt.Fprintf(tw, "  %s\t%s\n", v.Name, FormatBytes(v.Downloaded))
+	}
+	tw.Flush()
+}
+
+// Number of repos listed by PrintTimings
+const slowestRepos = 10
+
+// Print the wrap-up line of the run: numbers of repos by outcome of their
+// update and the run time, failed ones in red; the updated ones are pending
+// with dry run
+func PrintCounters(reports []straightup.Result, took time.Duration) {
+	counts := make(map[string]int)
+	for _, v := range reports {
+		counts[v.Outcome()]++
+	}
+	updated := "updated"
+	if *dryRun {
+		updated = "pending"
+	}
+	failed := output.String("failed", strconv.Itoa(counts[straightup.OutcomeFailed]))
+	if counts[straightup.OutcomeFailed] > 0 {
+		failed = failed.Foreground(output.Color(palette.Error)).Bold()
+	}
+	if took >= time.Second {
+		took = took.Round(time.Second)
+	} else {
+		took = took.Round(time.Millisecond)
+	}
+	fmt.Printf("%s %d  unchanged %d  skipped %d  %s  in %s\n", updated, counts[straightup.OutcomeUpdated],
+		counts[straightup.OutcomeUnchanged], counts[straightup.OutcomeSkipped], failed, took)
+}
+
+// Print the slowest repos and the total run time, with durations of update
+// phases if phases is set
+func PrintTimings(reports []straightup.Result, total time.Duration, phases bool) {
+	rows := slices.Clone(reports)
+	slices.SortStableFunc(rows, func(a, b straightup.Result) int {
+		return cmp.Compare(b.Duration, a.Duration)
+	})
+	rows = rows[:min(len(rows), slowestRepos)]
+
+	if len(rows) > 1 {
+		fmt.Println(output.String("Slowest repos:").Bold())
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, v := range rows {
+			fmt.Fprintf(tw, "  %s\t%s", v.Name, v.Duration.Round(time.Millisecond))
+			if phases {
+				for _, ph := range []string{straightup.PhaseOpen, straightup.PhaseFetch, straightup.PhaseMerge, straightup.PhaseLog} {
+					fmt.Fprintf(tw, "\t%s %s", ph, v.Phases[ph].Round(time.Millisecond))
+				}
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+	}
+	fmt.Println(output.String("Total run time", total.Round(time.Millisecond).String()).Faint())
+}
+
+// Colorize text by repo status
+func statusStyle(s termenv.Style, status string) termenv.Style {
+	switch status {
+	case straightup.StatusUpdated, straightup.StatusPending:
+		return s.Foreground(output.Color(palette.Updated))
+	case straightup.StatusFailed, straightup.StatusPartial, straightup.StatusDiverged, straightup.StatusRewritten,
+		straightup.StatusForced:
+		return s.Foreground(output.Color(palette.Error))
+	case straightup.StatusSkipped, straightup.StatusAttention, straightup.StatusDirty, straightup.StatusDetached,
+		straightup.StatusPinned, straightup.StatusInterrupted:
+		return s.Foreground(output.Color(palette.Notice))
+	}
+	return s.Faint()
+}
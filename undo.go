@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Status of repo reset by undo in history record
+const statusUndone = "undone"
+
+// HEAD of repo has moved since the run to undo
+var ErrMovedSince = errors.New("HEAD has moved since the run")
+
+// The most recent run of history records (oldest first) which has updated
+// repos not undone yet, nil if there is none; repos of the run undone
+// already are returned too
+func LastUndoableRun(records []HistoryRecord) (*HistoryRecord, map[string]bool) {
+	undone := make(map[time.Time]map[string]bool)
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := &records[i]
+		if rec.Undo != nil {
+			t := rec.Undo.UTC()
+			if undone[t] == nil {
+				undone[t] = make(map[string]bool)
+			}
+			for _, v := range rec.Changes {
+				undone[t][v.Name] = true
+			}
+			continue
+		}
+		done := undone[rec.Time.UTC()]
+		for _, v := range rec.Changes {
+			if undoable(v) && !done[v.Name] {
+				return rec, done
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Report whether repo result of a run can be undone: the run has moved it
+func undoable(v straightup.Result) bool {
+	return v.NewCommits > 0 && v.PreviousHash != "" && v.NewHash != "" && v.Status != straightup.StatusFailed
+}
+
+// Reset repo p hard from the commit the run has updated it to back to the
+// one before the run and set update marker there; HEAD moved since the run
+// and uncommitted changes are refused unless force is set
+func UndoGitRepo(p string, from, to plumbing.Hash, force bool) error {
+	r, err := git.PlainOpen(p)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if head.Hash() != from && !force {
+		return fmt.Errorf("%w: at %s instead of %s, use --force to reset anyway", ErrMovedSince, straightup.ShortHash(head.Hash().String()), straightup.ShortHash(from.String()))
+	}
+	if _, err = r.CommitObject(to); err != nil {
+		return fmt.Errorf("commit %s before the run: %w", to, err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if !force {
+		files, err := straightup.DirtyFiles(w)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("%d files have uncommitted changes, use --force to discard them", len(files))
+		}
+	}
+	if err = w.Reset(&git.ResetOptions{Commit: to, Mode: git.HardReset}); err != nil {
+		return err
+	}
+	_, err = straightup.SetUpdateMarker(r, plumbing.NewHashReference(plumbing.HEAD, to))
+	return err
+}
+
+// updstraight undo [--force]
+func runUndo(args []string) {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	force := fs.Bool("force", false, "reset repos whose HEAD has moved since the run, discard uncommitted changes")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: updstraight undo [--force]")
+		fmt.Fprintln(fs.Output(), "Reset repos updated by the last recorded run back to their commits before it.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(ExitSetup)
+	}
+
+	LockRun(context.Background())
+	p, err := HistoryPath()
+	if err != nil {
+		fatal(err)
+	}
+	records, err := ReadHistory(p)
+	if err != nil {
+		fatal(err)
+	}
+	run, done := LastUndoableRun(records)
+	if run == nil {
+		fmt.Println(output.String("no run to undo recorded in", p).Faint())
+		return
+	}
+	fmt.Println(output.String("Undoing the run of", run.Time.Format("2006-01-02 15:04")).Bold())
+
+	var (
+		failed  bool
+		changed []string
+		undo    = HistoryRecord{Time: time.Now(), Undo: &run.Time, Changes: []straightup.Result{}}
+	)
+	for _, v := range run.Changes {
+		if !undoable(v) || done[v.Name] {
+			continue
+		}
+		undo.Repos++
+		err := UndoGitRepo(v.Path, plumbing.NewHash(v.NewHash), plumbing.NewHash(v.PreviousHash), *force)
+		switch {
+		case errors.Is(err, ErrMovedSince):
+			warn("skipped", v.Name+":", err.Error())
+		case err != nil:
+			failed = true
+			fmt.Println(output.String("Failed", v.Path+":", straightup.Scrub(err.Error())).Foreground(output.Color(palette.Error)))
+		default:
+			changed = append(changed, v.Name)
+			undo.Changes = append(undo.Changes, straightup.Result{
+				Name: v.Name, Path: v.Path, PreviousHash: v.NewHash, NewHash: v.PreviousHash, Status: statusUndone,
+			})
+			fmt.Println(
+				output.String("Undone", v.Name).Foreground(output.Color(palette.Notice)),
+				output.String(straightup.ShortHash(v.NewHash), "->", straightup.ShortHash(v.PreviousHash)).Foreground(output.Color(palette.Hash)),
+			)
+		}
+	}
+	if len(undo.Changes) > 0 {
+		if err := AppendHistory(p, undo); err != nil {
+			warn("cannot write history:", err.Error())
+		}
+	}
+
+	if err := RestartEmacsIfNeeded(changed); err != nil {
+		log.Print(err)
+		os.Exit(ExitRestart)
+	}
+	if failed {
+		os.Exit(ExitFailed)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+func TestLastUndoableRun(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2026, 10, 14, h, 0, 0, 0, time.UTC) }
+	updated := func(names ...string) []straightup.Result {
+		l := []straightup.Result{}
+		for _, v := range names {
+			l = append(l, straightup.Result{Name: v, Status: straightup.StatusUpdated, NewCommits: 1, PreviousHash: "aaa", NewHash: "bbb"})
+		}
+		return l
+	}
+	records := []HistoryRecord{
+		{Time: at(1), Changes: updated("org")},
+		{Time: at(2), Changes: updated("org", "magit")},
+		{Time: at(3), Changes: updated()}, // nothing updated
+	}
+	if run, done := LastUndoableRun(records); run == nil || !run.Time.Equal(at(2)) || len(done) != 0 {
+		t.Errorf("run to undo = %v, undone %v, want the one of 2:00", run, done)
+	}
+
+	// magit is skipped by undo, it's left for the next one
+	undone := at(2)
+	records = append(records, HistoryRecord{Time: at(4), Undo: &undone, Changes: updated("org")})
+	if run, done := LastUndoableRun(records); run == nil || !run.Time.Equal(at(2)) || !done["org"] || done["magit"] {
+		t.Errorf("run to undo after partial undo = %v, undone %v, want the one of 2:00 with org undone", run, done)
+	}
+	records = append(records, HistoryRecord{Time: at(5), Undo: &undone, Changes: updated("magit")})
+	if run, _ := LastUndoableRun(records); run == nil || !run.Time.Equal(at(1)) {
+		t.Errorf("run to undo after undo = %v, want the one of 1:00", run)
+	}
+	if run, _ := LastUndoableRun(records[2:3]); run != nil {
+		t.Errorf("run to undo of no updates = %v", run)
+	}
+}
+
+func TestUndoGitRepo(t *testing.T) {
+	p := t.TempDir()
+	r, err := git.PlainInit(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(content string) plumbing.Hash {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(p, "pkg.el"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add("pkg.el"); err != nil {
+			t.Fatal(err)
+		}
+		h, err := w.Commit(content, &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t@e.x", When: time.Now()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	before := commit("v1")
+	after := commit("v2")
+
+	if err = UndoGitRepo(p, before, after, false); !errors.Is(err, ErrMovedSince) {
+		t.Errorf("undo of moved repo = %v, want %v", err, ErrMovedSince)
+	}
+	if err = UndoGitRepo(p, after, before, false); err != nil {
+		t.Fatal(err)
+	}
+	head, _ := r.Head()
+	tag, err := straightup.MarkedCommit(r)
+	if head.Hash() != before || err != nil || tag.Hash() != before {
+		t.Errorf("undone repo at %s, marker %v %v, want both at %s", head.Hash(), tag, err, before)
+	}
+}
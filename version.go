@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	buildinfo "runtime/debug"
+)
+
+// Build metadata, set with:
+// go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// Version string of the binary: ldflags values, build info of the main
+// module as a fallback, plus the version of linked go-git
+func Version() string {
+	gogit := "unknown"
+	if bi, ok := buildinfo.ReadBuildInfo(); ok {
+		if version == "" {
+			version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && commit == "":
+				commit = s.Value
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+		for _, m := range bi.Deps {
+			if m.Path == "github.com/go-git/go-git/v5" {
+				gogit = m.Version
+				if m.Replace != nil {
+					gogit = m.Replace.Version
+				}
+			}
+		}
+	}
+	for _, v := range []*string{&version, &commit, &date} {
+		if *v == "" {
+			*v = "unknown"
+		}
+	}
+	return fmt.Sprintf("updstraight %s (commit %s, built %s)\ngo-git %s, %s %s/%s",
+		version, commit, date, gogit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
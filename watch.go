@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Elisp form printing seconds since the last input event of the user, 0 if
+// Emacs is busy with a command
+const idleForm = "(float-time (or (current-idle-time) 0))"
+
+// Changed repos of watch cycles whose Emacs restart is deferred until the
+// user is away
+var deferredRestart []string
+
+// Run update cycles every --watch interval until SIGINT or SIGTERM, which let
+// the cycle in progress finish, the second one interrupts it like a single
+// run; SIGHUP starts the next cycle at once; exit code is returned
+func Watch(profiles []straightup.Profile) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	go func() {
+		<-sig
+		close(stopping)
+		warn("stopping after the update in progress, press Ctrl-C again to interrupt it")
+		<-sig
+		signal.Stop(sig)
+		interrupted.Store(true)
+		cancel()
+		warn("interrupted, waiting for repos in progress, press Ctrl-C again to exit immediately")
+	}()
+
+	for n := 1; ; n++ {
+		watchCycle(ctx, n, profiles)
+		select {
+		case <-stopping:
+			if interrupted.Load() {
+				return ExitInterrupted
+			}
+			return 0
+		default:
+		}
+		log.Printf("next update at %s, SIGHUP starts it now", time.Now().Add(*watch).Format("2006-01-02 15:04"))
+		t := time.NewTimer(*watch)
+		select {
+		case <-t.C:
+		case <-hup:
+			t.Stop()
+			log.Print("SIGHUP received, updating now")
+		case <-stopping:
+			t.Stop()
+			return 0
+		}
+	}
+}
+
+// Run update cycle n under the run lock, cycles meeting another run past
+// --lock-wait are skipped; summary of the cycle is logged
+func watchCycle(ctx context.Context, n int, profiles []straightup.Profile) {
+	p, err := RunLockPath()
+	if err == nil {
+		runLock, err = AcquireRunLock(ctx, p, *lockWait)
+	}
+	switch {
+	case errors.Is(err, ErrLocked):
+		log.Printf("update %d skipped: %s", n, err)
+		return
+	case err != nil:
+		log.Printf("update %d skipped: cannot lock %s: %s", n, p, err)
+		return
+	}
+	defer func() {
+		runLock.Close()
+		runLock = nil
+	}()
+
+	emacsRestarted = false
+	if !*allProfiles {
+		if pins, err = LoadLockfile(); err != nil {
+			warn("cannot read lockfile:", err.Error())
+		}
+	}
+	start := time.Now()
+	reports, code := runUpdate(ctx, profiles)
+	updated, commits, failed := NewHistoryRecord(reports, start).Totals()
+	line := fmt.Sprintf("update %d: %d of %d repos updated, %d commits", n, updated, len(reports), commits)
+	if failed > 0 {
+		line += fmt.Sprintf(", %d failed", failed)
+	}
+	if emacsRestarted {
+		line += ", Emacs restarted"
+	}
+	if len(deferredRestart) > 0 {
+		line += ", Emacs restart deferred"
+	}
+	log.Printf("%s (took %s, exit code %d)", line, time.Since(start).Round(time.Millisecond), code)
+}
+
+// Changed repos to restart Emacs for: with --watch-idle the ones deferred by
+// earlier watch cycles are added and all of them are deferred again if the
+// user has not been idle in Emacs that long; the daemon, which does not
+// answer, is taken for idle
+func WatchRestartRepos(changed []string) []string {
+	if *watch <= 0 || *watchIdle <= 0 {
+		return changed
+	}
+	all := deferredRestart
+	for _, v := range changed {
+		if !slices.Contains(all, v) {
+			all = append(all, v)
+		}
+	}
+	deferredRestart = nil
+	if restart, _ := SplitRestartRepos(all); len(restart) == 0 || *noRestart || *dryRun || interrupted.Load() {
+		return all
+	}
+	idle, err := emacsIdleTime()
+	if err != nil {
+		debug("emacs", time.Now(), "idle time is unknown: ", err)
+		return all
+	}
+	if idle >= *watchIdle {
+		return all
+	}
+	deferredRestart = all
+	if !*jsonOutput {
+		fmt.Println(output.String("Emacs restart is deferred to the next update, Emacs has been idle for", idle.Round(time.Second).String(), "only").Foreground(output.Color(palette.Warning)).Bold(),
+			output.String("(changed: "+strings.Join(all, ", ")+"; --watch-idle "+watchIdle.String()+")").Faint())
+	}
+	return nil
+}
+
+// Time since the last input event of the user in Emacs daemon addressed by
+// --socket-name or --server-file
+func emacsIdleTime() (time.Duration, error) {
+	client, _, err := emacsclient()
+	if err != nil {
+		return 0, err
+	}
+	out, err := exec.Command(client[0], append(client[1:], "-w", "5", "-e", idleForm)...).Output()
+	if err != nil {
+		return 0, err
+	}
+	return parseIdleTime(string(out))
+}
+
+// Idle time printed by emacsclient for idleForm, in seconds
+func parseIdleTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("unexpected idle time of Emacs: %q", s)
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
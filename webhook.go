@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+// Payload of --webhook request summarizing the run
+type WebhookPayload struct {
+	Host          string           `json:"host"`
+	DryRun        bool             `json:"dry_run,omitempty"`
+	Repos         int              `json:"repos"`   // processed by the run
+	Commits       int              `json:"commits"` // new commits of updated repos
+	Updated       []WebhookRepo    `json:"updated"`
+	Failed        []WebhookFailure `json:"failed"`
+	RestartNeeded bool             `json:"restart_needed"`
+	Restarted     bool             `json:"restarted"`
+	Interrupted   bool             `json:"interrupted,omitempty"`
+	Duration      time.Duration    `json:"duration_ns"`
+}
+
+// Updated repo of webhook payload
+type WebhookRepo struct {
+	Name    string `json:"name"`
+	Commits int    `json:"commits"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+}
+
+// Failed repo of webhook payload
+type WebhookFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// Webhook payload of the run by reports of repos
+func NewWebhookPayload(reports []straightup.Result, took time.Duration) WebhookPayload {
+	host, _ := os.Hostname()
+	wp := WebhookPayload{
+		Host:          host,
+		DryRun:        *dryRun,
+		Repos:         len(reports),
+		Updated:       []WebhookRepo{},
+		Failed:        []WebhookFailure{},
+		RestartNeeded: len(RestartTriggers(reports)) > 0,
+		Restarted:     emacsRestarted,
+		Interrupted:   interrupted.Load(),
+		Duration:      took,
+	}
+	for _, v := range reports {
+		switch v.Status {
+		case straightup.StatusUpdated, straightup.StatusPending, straightup.StatusForced:
+			wp.Updated = append(wp.Updated, WebhookRepo{v.Name, v.NewCommits, v.PreviousHash, v.NewHash})
+			wp.Commits += v.NewCommits
+		case straightup.StatusFailed, straightup.StatusPartial:
+			wp.Failed = append(wp.Failed, WebhookFailure{v.Name, v.Error})
+		}
+	}
+	return wp
+}
+
+var webhookTpl *template.Template
+
+// Load template of webhook body from file p, e.g. for Slack or Discord
+// message format; json function quotes values as JSON
+func LoadWebhookTemplate(p string) (*template.Template, error) {
+	p, err := straightup.ExpandHome(p)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(p).Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(string(b))
+}
+
+// Post payload of the run to webhook URL u as JSON document or rendered by
+// tpl if it's set
+func PostWebhook(u string, tpl *template.Template, wp WebhookPayload) error {
+	var body bytes.Buffer
+	if tpl != nil {
+		if err := tpl.Execute(&body, wp); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&body).Encode(wp); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "updstraight")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *proxyURL != "" {
+		proxy, err := url.Parse(*proxyURL)
+		if err != nil {
+			return err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Send webhook of the run if --webhook is set, failure is only reported: it
+// does not change the result of the run
+func SendWebhook(reports []straightup.Result, took time.Duration) {
+	if *webhook == "" {
+		return
+	}
+	if err := PostWebhook(*webhook, webhookTpl, NewWebhookPayload(reports, took)); err != nil {
+		warn("webhook failed:", straightup.Scrub(err.Error()))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1buran/updstraight/pkg/straightup"
+)
+
+func TestPostWebhook(t *testing.T) {
+	var got []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		got, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	wp := NewWebhookPayload([]straightup.Result{
+		{Name: "magit", Status: straightup.StatusUpdated, NewCommits: 3, PreviousHash: "aaa", NewHash: "bbb"},
+		{Name: "org", Status: straightup.StatusFailed, Error: "connection refused"},
+		{Name: "dash.el", Status: straightup.StatusUpToDate},
+	}, time.Second)
+	if err := PostWebhook(srv.URL, nil, wp); err != nil {
+		t.Fatal(err)
+	}
+	var sent WebhookPayload
+	if err := json.Unmarshal(got, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Repos != 3 || sent.Commits != 3 || len(sent.Updated) != 1 || sent.Updated[0].Name != "magit" ||
+		len(sent.Failed) != 1 || sent.Failed[0].Error != "connection refused" || !sent.RestartNeeded {
+		t.Errorf("payload = %s", got)
+	}
+
+	p := filepath.Join(t.TempDir(), "slack.tmpl")
+	tpl := `{"text": {{ printf "%d repos updated, failed: %v" (len .Updated) .Failed | json }}}`
+	if err := os.WriteFile(p, []byte(tpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if webhookTpl, err := LoadWebhookTemplate(p); err != nil {
+		t.Fatal(err)
+	} else if err = PostWebhook(srv.URL, webhookTpl, wp); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"text": "1 repos updated, failed: [{org connection refused}]"}`; string(got) != want {
+		t.Errorf("templated body = %s, want %s", got, want)
+	}
+}
+
+func TestPostWebhookFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	if err := PostWebhook(srv.URL, nil, WebhookPayload{}); err == nil {
+		t.Error("webhook answered 404 has succeeded")
+	}
+}